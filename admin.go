@@ -0,0 +1,470 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Admin Handlers ---
+//
+// These endpoints let tests drive the mock into specific states. They are
+// only registered when the server is started with -enable-admin.
+
+// adminTokenHeader carries -admin-token.
+const adminTokenHeader = "X-Admin-Token"
+
+// adminEndpoint wraps an admin handler so that, with -admin-token, only
+// requests presenting the token reach it. Shared mocks can then keep test
+// suites that do not know the token from resetting or steering them.
+func (s *Server) adminEndpoint(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.AdminToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(adminTokenHeader)), []byte(s.AdminToken)) != 1 {
+			s.writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "Invalid or missing admin token")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleAdminReset deletes every order and cancels the issuances,
+// revocations and webhooks scheduled for them, so one long-running mock
+// can serve many test suites. Sequential IDs start over at firstOrderID.
+// Sessions stay valid, and serials already issued stay reserved.
+func (s *Server) handleAdminReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	cancelled := s.stopBackgroundLocked()
+	cleared := len(s.orders)
+	clear(s.orders)
+	clear(s.injected)
+	clear(s.idempotent)
+	s.nextID = firstOrderID
+	s.saveStoreLocked()
+	s.mu.Unlock()
+
+	log.Printf("[Admin] Reset: cleared %d orders, cancelled %d scheduled changes", cleared, cancelled)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cleared":   cleared,
+		"cancelled": cancelled,
+	})
+}
+
+func (s *Server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	token := r.PathValue("token")
+
+	s.mu.Lock()
+	sess, ok := s.sessions[token]
+	if ok {
+		sess.Expired = true
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+
+	log.Printf("[Admin] Session %s expired", token)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":  token,
+		"status": "expired",
+	})
+}
+
+// handleAdminInject registers (POST) or clears (DELETE) a canned response
+// that status and collect return for a single order ID.
+func (s *Server) handleAdminInject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		s.mu.Lock()
+		delete(s.injected, orderID)
+		s.mu.Unlock()
+		log.Printf("[Admin] Cleared injection for order %d", orderID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var inj Injection
+	if err := json.NewDecoder(r.Body).Decode(&inj); err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if inj.StatusCode == 0 {
+		inj.StatusCode = http.StatusInternalServerError
+	}
+	if inj.StatusCode < 100 || inj.StatusCode > 999 {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid statusCode")
+		return
+	}
+	if inj.ContentType == "" {
+		inj.ContentType = "application/json"
+	}
+
+	s.mu.Lock()
+	s.injected[orderID] = &inj
+	s.mu.Unlock()
+
+	log.Printf("[Admin] Order %d status/collect will return %d", orderID, inj.StatusCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sslId":     orderID,
+		"injection": inj,
+	})
+}
+
+// handleAdminIssue completes issuance of a pending order immediately
+// instead of waiting for the issuance delay.
+func (s *Server) handleAdminIssue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	var status string
+	issued := false
+	if ok {
+		issued = s.issueOrderLocked(orderID)
+		status = order.Status
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if !issued {
+		s.writeError(w, http.StatusConflict, errCodeOrderState, "Order is not pending (status: "+status+")")
+		return
+	}
+
+	log.Printf("[Admin] Order %d issued on demand", orderID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sslId":  orderID,
+		"status": status,
+	})
+}
+
+// Approval modes for -approval.
+const (
+	approvalAuto   = "auto"   // Orders issue after the issuance delay
+	approvalManual = "manual" // Orders wait for /admin/approve
+)
+
+// AdminOrderRequest names the order acted on by approve and reject.
+type AdminOrderRequest struct {
+	SslId flexID `json:"sslId"`
+}
+
+// decodeAdminOrder reads an AdminOrderRequest and returns its order ID, or
+// writes a 400 and returns false.
+func (s *Server) decodeAdminOrder(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return 0, false
+	}
+	var req AdminOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return 0, false
+	}
+	orderID, err := strconv.Atoi(string(req.SslId))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return 0, false
+	}
+	return orderID, true
+}
+
+// handleAdminApprove releases an order held by -approval=manual. It is
+// then issued like any other order: after its issuance delay, or on the
+// Nth status poll with -issue-after-polls.
+func (s *Server) handleAdminApprove(w http.ResponseWriter, r *http.Request) {
+	orderID, ok := s.decodeAdminOrder(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	var status string
+	approved := false
+	if ok {
+		if order.AwaitingApproval {
+			order.AwaitingApproval = false
+			order.UpdatedAt = time.Now()
+			approved = true
+			if !order.DCVPending {
+				s.startIssuanceLocked(order)
+			}
+			s.saveStoreLocked()
+		}
+		status = order.Status
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if !approved {
+		s.writeError(w, http.StatusConflict, errCodeOrderState, "Order is not awaiting approval (status: "+status+")")
+		return
+	}
+	log.Printf("[Admin] Order %d approved", orderID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sslId":  orderID,
+		"status": status,
+	})
+}
+
+// handleAdminReject declines a pending order, approved or not. Issuance
+// already scheduled for it then does nothing.
+func (s *Server) handleAdminReject(w http.ResponseWriter, r *http.Request) {
+	orderID, ok := s.decodeAdminOrder(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	var status string
+	declined := false
+	if ok {
+		status = order.Status
+		if status == "pending" {
+			order.Status = "declined"
+			order.AwaitingApproval = false
+			order.DCVPending = false
+			order.UpdatedAt = time.Now()
+			s.saveStoreLocked()
+			declined = true
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if !declined {
+		s.writeError(w, http.StatusConflict, errCodeOrderState, "Order is not pending (status: "+status+")")
+		return
+	}
+
+	log.Printf("[Admin] Order %d declined", orderID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sslId":  orderID,
+		"status": "declined",
+	})
+}
+
+// handleAdminConfig reports the Options this server runs with, so tests
+// can confirm the scenario the mock was started with. It describes this
+// Server rather than the process's flags, which differ for a server
+// embedded with NewServer.
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(optionsConfig(s.Options))
+}
+
+// optionsConfig maps each field of opts to its value, keyed by the field
+// name in lowerCamelCase. Durations and parsed specs such as
+// LatencySchedule are given in their flag syntax; the admin token is only
+// reported as set.
+func optionsConfig(opts Options) map[string]interface{} {
+	v := reflect.ValueOf(opts)
+	config := make(map[string]interface{}, v.NumField())
+	for i := range v.NumField() {
+		config[lowerCamel(v.Type().Field(i).Name)] = configValue(v.Field(i))
+	}
+	if opts.AdminToken != "" {
+		config["adminToken"] = "(set)"
+	}
+	return config
+}
+
+var stringerType = reflect.TypeFor[fmt.Stringer]()
+
+// configValue is v as optionsConfig reports it: Stringers, and slices of
+// them, as strings, anything else as encoding/json renders it.
+func configValue(v reflect.Value) interface{} {
+	if v.Type().Implements(stringerType) {
+		return v.Interface().(fmt.Stringer).String()
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Implements(stringerType) {
+		out := make([]string, v.Len())
+		for i := range out {
+			out[i] = v.Index(i).Interface().(fmt.Stringer).String()
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// lowerCamel lower-cases the leading word of a Go field name, acronyms
+// included: CORSOrigins becomes corsOrigins and DCV dcv.
+func lowerCamel(name string) string {
+	n := 0
+	for n < len(name) && 'A' <= name[n] && name[n] <= 'Z' {
+		n++
+	}
+	if n > 1 && n < len(name) {
+		n-- // The last capital starts the next word
+	}
+	return strings.ToLower(name[:n]) + name[n:]
+}
+
+type GenCSRRequest struct {
+	CN      string   `json:"cn"`
+	SANs    []string `json:"sans"`
+	KeyType string   `json:"keyType"`
+}
+
+type GenCSRResponse struct {
+	Csr        string `json:"csr"`
+	PrivateKey string `json:"privateKey"`
+	KeyType    string `json:"keyType"`
+}
+
+// handleAdminGenCSR generates a key pair and a CSR for it, so the mock can
+// be exercised without an external CSR tool. SANs that parse as IP
+// addresses become IP SANs; everything else is a DNS name.
+func (s *Server) handleAdminGenCSR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	var req GenCSRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if req.CN == "" {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "cn is required")
+		return
+	}
+	if req.KeyType == "" {
+		req.KeyType = "rsa2048"
+	}
+
+	key, err := generateKey(req.KeyType)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate(req.CN, req.SANs), key)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to create CSR: "+err.Error())
+		return
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to encode private key: "+err.Error())
+		return
+	}
+
+	log.Printf("[Admin] Generated %s CSR for %s", req.KeyType, req.CN)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GenCSRResponse{
+		Csr:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		KeyType:    req.KeyType,
+	})
+}
+
+// csrTemplate is a CSR for cn and sans. SANs that parse as IP addresses
+// become IP SANs; everything else is a DNS name.
+func csrTemplate(cn string, sans []string) *x509.CertificateRequest {
+	tmpl := &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+	return tmpl
+}
+
+// generateKey creates a private key of the named type.
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa3072":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "rsa4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported keyType %q (want rsa2048, rsa3072, rsa4096, ecdsa-p256, ecdsa-p384 or ed25519)", keyType)
+}
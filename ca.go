@@ -0,0 +1,414 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- Mock CA ---
+
+// mockCA is a self-signed root generated at startup. Issued orders get a
+// leaf signed by it, so collected certificates parse and chain-verify
+// against the root served at /api/ssl/v1/ca.
+//
+// With -chains, leaves are instead signed by an intermediate that exists
+// in several variants: the same subject and key, cross-signed by a
+// different root each. Any variant's intermediate verifies the leaf, so
+// the variant only decides which root the returned chain leads to.
+//
+// With -bad-ca, every CA certificate is deliberately unacceptable, so
+// clients can be tested on refusing the chains of the leaves it signs.
+type mockCA struct {
+	cert    *x509.Certificate
+	certPEM string
+	key     crypto.Signer
+
+	issuer    *x509.Certificate // Signs leaves: the root, or the intermediate
+	issuerKey crypto.Signer
+	chains    []caChain // From -chains; the first is the default
+	skiMethod string    // From -ski-method, see subjectKeyID
+
+	serialMu sync.Mutex
+	serials  map[string]bool // Hex serials ever handed out, including loaded ones
+}
+
+// caChain is one variant of the intermediate and the root it chains to.
+type caChain struct {
+	name            string
+	rootPEM         string
+	intermediatePEM string
+}
+
+// Flaws -bad-ca can give the CA certificates.
+const (
+	badCAExpired = "expired" // Expired a year ago
+	badCAWeak    = "weak"    // 1024-bit RSA keys
+)
+
+// newCAKey returns a key for a CA certificate: P-256, or 1024-bit RSA
+// for a weak CA.
+func newCAKey(badCA string) (crypto.Signer, error) {
+	if badCA == badCAWeak {
+		return rsa.GenerateKey(rand.Reader, 1024)
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// newMockCA creates the root and, for each chain name, an intermediate
+// variant. The first variant is signed by the main root, later ones by
+// a root of their own. badCA is "" or one of the badCA flaws.
+func newMockCA(chainNames []string, skiMethod, badCA string) (*mockCA, error) {
+	key, err := newCAKey(badCA)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if badCA == badCAExpired {
+		now = now.AddDate(-11, 0, 0) // caTemplate's ten years then ended a year ago
+	}
+	tmpl := caTemplate("Mock Setigo Root CA", now)
+	cert, certPEM, err := createCert(tmpl, tmpl, key.Public(), key, skiMethod)
+	if err != nil {
+		return nil, err
+	}
+	c := &mockCA{cert: cert, certPEM: certPEM, key: key, issuer: cert, issuerKey: key, skiMethod: skiMethod}
+	c.serials = map[string]bool{cert.SerialNumber.Text(16): true}
+	if len(chainNames) == 0 {
+		return c, nil
+	}
+
+	intKey, err := newCAKey(badCA)
+	if err != nil {
+		return nil, err
+	}
+	c.issuerKey = intKey
+	for i, name := range chainNames {
+		root, rootPEM, rootKey := cert, certPEM, key
+		if i > 0 {
+			k, err := newCAKey(badCA)
+			if err != nil {
+				return nil, err
+			}
+			rt := caTemplate("Mock Setigo Root CA ("+name+")", now)
+			if root, rootPEM, err = createCert(rt, rt, k.Public(), k, skiMethod); err != nil {
+				return nil, err
+			}
+			c.reserveSerial(root.SerialNumber.Text(16))
+			rootKey = k
+		}
+		it := caTemplate("Mock Setigo Intermediate CA", now)
+		it.MaxPathLenZero = true
+		inter, interPEM, err := createCert(it, root, intKey.Public(), rootKey, skiMethod)
+		if err != nil {
+			return nil, err
+		}
+		c.reserveSerial(inter.SerialNumber.Text(16))
+		if i == 0 {
+			c.issuer = inter
+		}
+		c.chains = append(c.chains, caChain{name: name, rootPEM: rootPEM, intermediatePEM: interPEM})
+	}
+	return c, nil
+}
+
+// chain returns the named variant, or the default one for "". It returns
+// nil, true when no -chains are configured and name is "".
+func (c *mockCA) chain(name string) (*caChain, bool) {
+	if name == "" {
+		if len(c.chains) == 0 {
+			return nil, true
+		}
+		return &c.chains[0], true
+	}
+	for i := range c.chains {
+		if c.chains[i].name == name {
+			return &c.chains[i], true
+		}
+	}
+	return nil, false
+}
+
+// caTemplate returns a ten-year CA certificate template.
+func caTemplate(cn string, now time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"Mock Setigo"}, CommonName: cn},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+// createCert assigns a serial to tmpl, signs it and returns the parsed
+// certificate along with its PEM encoding.
+func createCert(tmpl, parent *x509.Certificate, pub crypto.PublicKey, key crypto.Signer, skiMethod string) (*x509.Certificate, string, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, "", err
+	}
+	tmpl.SerialNumber = serial
+	if tmpl.SubjectKeyId, err = subjectKeyID(pub, skiMethod); err != nil {
+		return nil, "", err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, key)
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, "", err
+	}
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// issue signs a leaf for the CSR's public key, normalized subject and
+// SANs, valid from notBefore to notAfter and shaped by profile, if not
+// nil. It returns the certificate as PEM.
+func (c *mockCA) issue(csr *x509.CertificateRequest, notBefore, notAfter time.Time, profile *CertTemplate) (string, error) {
+	serial, err := c.newSerial()
+	if err != nil {
+		return "", err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               normalizeSubject(csr.Subject),
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		DNSNames:              normalizeDNSNames(csr.DNSNames),
+		IPAddresses:           csr.IPAddresses,
+		EmailAddresses:        csr.EmailAddresses,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if profile != nil {
+		profile.apply(tmpl, tmpl.Subject.CommonName)
+	}
+	if tmpl.SubjectKeyId, err = subjectKeyID(csr.PublicKey, c.skiMethod); err != nil {
+		return "", err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.issuer, csr.PublicKey, c.issuerKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// SubjectKeyIdentifier methods for -ski-method.
+const (
+	skiSHA1   = "sha1"   // RFC 5280 4.2.1.2 method (1)
+	skiSHA256 = "sha256" // RFC 7093 method (1): SHA-256 truncated to 160 bits
+)
+
+// subjectKeyID derives the key identifier of pub per method from
+// the subjectPublicKey bit string. The issuer's identifier becomes the
+// AuthorityKeyIdentifier of what it signs: x509.CreateCertificate copies
+// it from the parent.
+func subjectKeyID(pub crypto.PublicKey, method string) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	}
+	if method == skiSHA256 {
+		sum := sha256.Sum256(spki.PublicKey.Bytes)
+		return sum[:20], nil
+	}
+	sum := sha1.Sum(spki.PublicKey.Bytes)
+	return sum[:], nil
+}
+
+// newSerial returns a random serial that this CA has never used, so OCSP
+// and CRL lookups by serial stay unambiguous even when order IDs are
+// reused.
+func (c *mockCA) newSerial() (*big.Int, error) {
+	c.serialMu.Lock()
+	defer c.serialMu.Unlock()
+	for {
+		serial, err := randomSerial()
+		if err != nil {
+			return nil, err
+		}
+		if key := serial.Text(16); !c.serials[key] {
+			c.serials[key] = true
+			return serial, nil
+		}
+	}
+}
+
+// reserveSerial marks a hex serial as used, e.g. one loaded from
+// -store-file.
+func (c *mockCA) reserveSerial(serial string) {
+	c.serialMu.Lock()
+	defer c.serialMu.Unlock()
+	c.serials[serial] = true
+}
+
+// usedSerials returns every hex serial handed out so far, sorted.
+func (c *mockCA) usedSerials() []string {
+	c.serialMu.Lock()
+	defer c.serialMu.Unlock()
+	out := make([]string, 0, len(c.serials))
+	for serial := range c.serials {
+		out = append(out, serial)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// randomSerial returns a positive 128-bit serial number.
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial: %w", err)
+	}
+	return serial.Add(serial, big.NewInt(1)), nil
+}
+
+// handleCA serves the mock root certificate so clients can trust it.
+// ?chain= selects the root of a -chains variant.
+func (s *Server) handleCA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	root := s.ca.certPEM
+	if name := r.URL.Query().Get("chain"); name != "" {
+		ch, ok := s.ca.chain(name)
+		if !ok {
+			s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unknown chain: "+name)
+			return
+		}
+		root = ch.rootPEM
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"ca.crt\"")
+	s.setCacheControl(w)
+	w.Write([]byte(root))
+}
+
+// handleIntermediate serves the intermediate that signs leaves under
+// -chains, of the default or the ?chain= variant, as PEM or, with
+// ?format=der, DER. Without -chains leaves are signed by the root and
+// there is no intermediate to serve.
+func (s *Server) handleIntermediate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	name := r.URL.Query().Get("chain")
+	ch, ok := s.ca.chain(name)
+	if !ok {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unknown chain: "+name)
+		return
+	}
+	if ch == nil {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "No intermediate CA configured")
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"intermediate.crt\"")
+		s.setCacheControl(w)
+		w.Write([]byte(ch.intermediatePEM))
+	case "der":
+		block, _ := pem.Decode([]byte(ch.intermediatePEM))
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"intermediate.cer\"")
+		s.setCacheControl(w)
+		w.Write(block.Bytes)
+	default:
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unsupported format: "+format+" (want pem or der)")
+	}
+}
+
+// caFile is one certificate of the trust bundle.
+type caFile struct {
+	name string // File name stem inside the DER zip
+	pem  string
+}
+
+// trustBundle lists every CA certificate the mock issues under: the main
+// root and, with -chains, each variant's own root and intermediate. The
+// mock never rotates its CA, so there are no previous-generation roots.
+func (c *mockCA) trustBundle() []caFile {
+	files := []caFile{{name: "root", pem: c.certPEM}}
+	for i, ch := range c.chains {
+		if i > 0 {
+			files = append(files, caFile{name: "root-" + ch.name, pem: ch.rootPEM})
+		}
+		files = append(files, caFile{name: "intermediate-" + ch.name, pem: ch.intermediatePEM})
+	}
+	return files
+}
+
+// handleTrustBundle serves all CA certificates for trust bootstrap, as
+// concatenated PEM or, with ?format=der, a zip of one DER file each.
+func (s *Server) handleTrustBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	files := s.ca.trustBundle()
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"trust-bundle.pem\"")
+		s.setCacheControl(w)
+		for _, f := range files {
+			w.Write([]byte(f.pem))
+		}
+	case "der":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"trust-bundle.zip\"")
+		s.setCacheControl(w)
+		zw := zip.NewWriter(w)
+		for _, f := range files {
+			block, _ := pem.Decode([]byte(f.pem))
+			fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name + ".der", Method: zip.Deflate, Modified: time.Now()})
+			if err != nil {
+				log.Printf("[CA] trust bundle zip: %v", err)
+				return
+			}
+			fw.Write(block.Bytes)
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("[CA] trust bundle zip: %v", err)
+		}
+	default:
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unsupported format: "+format+" (want pem or der)")
+	}
+}
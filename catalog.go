@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// --- Product Catalog ---
+
+// Product is an orderable certificate type. Terms lists the allowed
+// terms in days; issued certificates never outlive MaxValidityDays
+// (0 = no cap) regardless of the term bought. Template, if set, shapes
+// the certificates issued for it. DCVMethods restricts how its orders may
+// complete -dcv; empty allows every method.
+type Product struct {
+	Code            int           `json:"code"`
+	Name            string        `json:"name"`
+	Terms           []int         `json:"terms"`
+	MaxValidityDays int           `json:"maxValidityDays,omitempty"`
+	Template        *CertTemplate `json:"template,omitempty"`
+	DCVMethods      []string      `json:"dcvMethods,omitempty"`
+}
+
+// defaultCatalog is used unless -catalog is given. Enroll requests
+// without a productCode get the first product.
+var defaultCatalog = []Product{
+	{Code: 287, Name: "Sectigo SSL Certificate (DV)", Terms: []int{365, 730}, MaxValidityDays: 398,
+		Template: &CertTemplate{Policies: []string{policyDV}}},
+	{Code: 288, Name: "Sectigo Wildcard SSL Certificate (DV)", Terms: []int{365, 730}, MaxValidityDays: 398,
+		Template: &CertTemplate{Policies: []string{policyDV}, Wildcard: true}},
+	{Code: 290, Name: "Sectigo Multi-Domain SSL Certificate (OV)", Terms: []int{365, 730}, MaxValidityDays: 398,
+		Template: &CertTemplate{Policies: []string{policyOV}}},
+	{Code: 331, Name: "Sectigo EV SSL Certificate", Terms: []int{365}, MaxValidityDays: 398,
+		Template: &CertTemplate{ExtKeyUsage: []string{"serverAuth"}, Policies: []string{policyEV}}},
+}
+
+// loadCatalog reads a JSON array of products from file.
+func loadCatalog(file string) ([]Product, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var products []Product
+	if err := json.Unmarshal(data, &products); err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, fmt.Errorf("catalog is empty")
+	}
+	seen := make(map[int]bool)
+	for i, p := range products {
+		if seen[p.Code] {
+			return nil, fmt.Errorf("product %d: duplicate code %d", i, p.Code)
+		}
+		seen[p.Code] = true
+		if len(p.Terms) == 0 {
+			return nil, fmt.Errorf("product %d: no terms", p.Code)
+		}
+		for _, t := range p.Terms {
+			if t <= 0 {
+				return nil, fmt.Errorf("product %d: invalid term %d", p.Code, t)
+			}
+		}
+		for _, m := range p.DCVMethods {
+			if !slices.Contains(dcvMethods, m) {
+				return nil, fmt.Errorf("product %d: unknown DCV method %q", p.Code, m)
+			}
+		}
+		if p.Template != nil {
+			if err := p.Template.validate(); err != nil {
+				return nil, fmt.Errorf("product %d: template: %w", p.Code, err)
+			}
+		}
+	}
+	return products, nil
+}
+
+// lookupProduct resolves an enroll request's product code and term: 0
+// means the first product, or that product's first term. It returns false
+// for an unknown product or a term the product does not offer.
+func (s *Server) lookupProduct(code, term int) (*Product, int, bool) {
+	p := &s.Catalog[0]
+	if code != 0 {
+		i := slices.IndexFunc(s.Catalog, func(p Product) bool { return p.Code == code })
+		if i < 0 {
+			return nil, 0, false
+		}
+		p = &s.Catalog[i]
+	}
+	if term == 0 {
+		term = p.Terms[0]
+	}
+	if !slices.Contains(p.Terms, term) {
+		return nil, 0, false
+	}
+	return p, term, true
+}
+
+// allowedDCVMethods returns the DCV methods the product with code accepts:
+// its DCVMethods, or all of them for an unrestricted or unknown product.
+func (s *Server) allowedDCVMethods(code int) []string {
+	i := slices.IndexFunc(s.Catalog, func(p Product) bool { return p.Code == code })
+	if i < 0 || len(s.Catalog[i].DCVMethods) == 0 {
+		return dcvMethods
+	}
+	return s.Catalog[i].DCVMethods
+}
+
+func (s *Server) handleProducts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Catalog)
+}
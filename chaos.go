@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- Chaos ---
+//
+// Random and pinned failures for resilience testing. A share of calls to
+// the chaos endpoints fails, either with an error status or a 200 whose
+// JSON body is cut off. Pins fail specific upcoming calls regardless of
+// the rate.
+
+const (
+	chaosModeError     = "error"     // Answer with StatusCode
+	chaosModeMalformed = "malformed" // Answer 200 with truncated JSON
+	chaosModeMixed     = "mixed"     // Pick one of the above per failure
+)
+
+// chaosEndpoints are the endpoint names chaos may be applied to.
+var chaosEndpoints = []string{"enroll", "status", "collect"}
+
+// ChaosPin fails the next Remaining calls to Endpoint, optionally only
+// those for one order.
+type ChaosPin struct {
+	Endpoint   string `json:"endpoint"`
+	SslId      int    `json:"sslId,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"` // Default 503
+	Malformed  bool   `json:"malformed,omitempty"`
+	Remaining  int    `json:"remaining,omitempty"` // Default 1
+}
+
+type ChaosConfig struct {
+	FailRate   float64    `json:"failRate"` // Percentage of calls that fail, 0-100
+	Mode       string     `json:"mode"`
+	StatusCode int        `json:"statusCode"`
+	Endpoints  []string   `json:"endpoints"`
+	Seed       *uint64    `json:"seed,omitempty"` // Reseeds the generator when set
+	Pins       []ChaosPin `json:"pins"`
+}
+
+// chaosFault is the failure chosen for one call.
+type chaosFault struct {
+	status    int
+	malformed bool
+}
+
+// chaosState is a server's current chaos config and its generator.
+type chaosState struct {
+	mu  sync.Mutex
+	cfg ChaosConfig
+	rng *rand.Rand
+}
+
+// validateChaosConfig fills in defaults and rejects invalid settings.
+func validateChaosConfig(cfg *ChaosConfig) error {
+	if cfg.FailRate < 0 || cfg.FailRate > 100 {
+		return fmt.Errorf("failRate must be between 0 and 100")
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = chaosModeError
+	}
+	if cfg.Mode != chaosModeError && cfg.Mode != chaosModeMalformed && cfg.Mode != chaosModeMixed {
+		return fmt.Errorf("mode must be %s, %s or %s", chaosModeError, chaosModeMalformed, chaosModeMixed)
+	}
+	if cfg.StatusCode == 0 {
+		cfg.StatusCode = http.StatusInternalServerError
+	}
+	if cfg.StatusCode < 400 || cfg.StatusCode > 599 {
+		return fmt.Errorf("statusCode must be a 4xx or 5xx status")
+	}
+	if cfg.Endpoints == nil {
+		cfg.Endpoints = chaosEndpoints
+	}
+	for _, e := range cfg.Endpoints {
+		if !slices.Contains(chaosEndpoints, e) {
+			return fmt.Errorf("unknown endpoint %q", e)
+		}
+	}
+	if cfg.Pins == nil {
+		cfg.Pins = []ChaosPin{}
+	}
+	for i := range cfg.Pins {
+		p := &cfg.Pins[i]
+		if !slices.Contains(chaosEndpoints, p.Endpoint) {
+			return fmt.Errorf("pin %d: unknown endpoint %q", i, p.Endpoint)
+		}
+		if p.StatusCode == 0 {
+			p.StatusCode = http.StatusServiceUnavailable
+		}
+		if p.StatusCode < 400 || p.StatusCode > 599 {
+			return fmt.Errorf("pin %d: statusCode must be a 4xx or 5xx status", i)
+		}
+		if p.Remaining <= 0 {
+			p.Remaining = 1
+		}
+	}
+	return nil
+}
+
+// setChaosConfig installs cfg, reseeding the generator if it carries a seed.
+func (s *Server) setChaosConfig(cfg ChaosConfig) {
+	s.chaos.mu.Lock()
+	defer s.chaos.mu.Unlock()
+	if cfg.Seed != nil {
+		s.chaos.rng = rand.New(rand.NewPCG(*cfg.Seed, *cfg.Seed))
+	} else {
+		cfg.Seed = s.chaos.cfg.Seed
+	}
+	s.chaos.cfg = cfg
+}
+
+// pickChaosFault decides whether this call to endpoint fails. Pins are
+// consumed first; otherwise the call fails with probability FailRate.
+func (s *Server) pickChaosFault(endpoint string, r *http.Request) *chaosFault {
+	s.chaos.mu.Lock()
+	defer s.chaos.mu.Unlock()
+	cfg := &s.chaos.cfg
+
+	if len(cfg.Pins) > 0 {
+		id := chaosOrderID(r)
+		for i := range cfg.Pins {
+			p := &cfg.Pins[i]
+			if p.Endpoint != endpoint || (p.SslId != 0 && p.SslId != id) {
+				continue
+			}
+			f := &chaosFault{status: p.StatusCode, malformed: p.Malformed}
+			if p.Remaining--; p.Remaining == 0 {
+				cfg.Pins = slices.Delete(cfg.Pins, i, i+1)
+			}
+			return f
+		}
+	}
+
+	if cfg.FailRate <= 0 || !slices.Contains(cfg.Endpoints, endpoint) {
+		return nil
+	}
+	if s.chaos.rng.Float64()*100 >= cfg.FailRate {
+		return nil
+	}
+	malformed := cfg.Mode == chaosModeMalformed || (cfg.Mode == chaosModeMixed && s.chaos.rng.IntN(2) == 0)
+	return &chaosFault{status: cfg.StatusCode, malformed: malformed}
+}
+
+// chaosOrderID returns the {id} of a status or collect request, or 0.
+func chaosOrderID(r *http.Request) int {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	return id
+}
+
+// mockFailHeader lets a client fail its own request under
+// -allow-header-faults, e.g. "X-Mock-Fail: 503".
+const mockFailHeader = "X-Mock-Fail"
+
+// headerFault returns the failure requested by the X-Mock-Fail header, or
+// nil if there is none. It returns false for a value that is not a 4xx or
+// 5xx status.
+func headerFault(r *http.Request) (*chaosFault, bool) {
+	v := r.Header.Get(mockFailHeader)
+	if v == "" {
+		return nil, true
+	}
+	status, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || status < 400 || status > 599 {
+		return nil, false
+	}
+	return &chaosFault{status: status}, true
+}
+
+// writeChaosFault sends the failure f.
+func (s *Server) writeChaosFault(w http.ResponseWriter, endpoint string, f *chaosFault) {
+	if f.malformed {
+		log.Printf("[Chaos] Returning malformed JSON from %s", endpoint)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"sslId": 1, "status": "iss`))
+		return
+	}
+	log.Printf("[Chaos] Returning %d from %s", f.status, endpoint)
+	s.writeError(w, f.status, errCodeUnknown, "Injected failure")
+}
+
+// handleAdminChaos reports (GET) or replaces (POST) the chaos config.
+func (s *Server) handleAdminChaos(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var cfg ChaosConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+			return
+		}
+		if err := validateChaosConfig(&cfg); err != nil {
+			s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid chaos config: "+err.Error())
+			return
+		}
+		s.setChaosConfig(cfg)
+		log.Printf("[Admin] Chaos set to %.1f%% %s on %s, %d pins", cfg.FailRate, cfg.Mode, strings.Join(cfg.Endpoints, ","), len(cfg.Pins))
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	s.chaos.mu.Lock()
+	data, err := json.Marshal(s.chaos.cfg)
+	s.chaos.mu.Unlock()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to encode chaos config")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,273 @@
+// Package client is a small typed client for the Sectigo SSL API as served
+// by mock-setigo. It only relies on the documented endpoints, so it can be
+// pointed at the real API as well.
+//
+//	c := client.New("http://localhost:3001")
+//	if _, err := c.Authenticate(ctx, "user", "secret"); err != nil {
+//		return err
+//	}
+//	order, err := c.Enroll(ctx, client.EnrollRequest{Csr: csrPEM})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenHeader carries the session token from Authenticate.
+const tokenHeader = "token"
+
+// Client talks to one API base URL. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken makes the client send token instead of authenticating first,
+// e.g. a token shared between tests.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient replaces http.DefaultClient, e.g. for custom TLS roots or
+// an httptest server's client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// New returns a client for the API at baseURL, such as
+// "http://localhost:3001".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Token returns the session token sent with requests.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// Error is a failed API call. Code is the Sectigo error code when the
+// server sent one, e.g. -40 for an unknown order.
+type Error struct {
+	StatusCode int
+	Code       int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("sectigo: %s (code %d, HTTP %d)", e.Message, e.Code, e.StatusCode)
+	}
+	return fmt.Sprintf("sectigo: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+type authRequest struct {
+	LoginName string `json:"loginName"`
+	Password  string `json:"password"`
+}
+
+type authResponse struct {
+	SslId   string `json:"sslId"`
+	Message string `json:"message"`
+}
+
+// Authenticate logs in and keeps the session token for later calls. It
+// also returns the token.
+func (c *Client) Authenticate(ctx context.Context, loginName, password string) (string, error) {
+	var resp authResponse
+	if err := c.do(ctx, http.MethodPost, "/api/ssl/v1/user/auth", authRequest{loginName, password}, &resp); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.token = resp.SslId
+	c.mu.Unlock()
+	return resp.SslId, nil
+}
+
+// EnrollRequest orders a certificate for a PEM CSR. Zero Term and
+// ProductCode pick the server's defaults.
+type EnrollRequest struct {
+	Csr            string `json:"csr"`
+	Term           int    `json:"term,omitempty"`
+	ProductCode    int    `json:"productCode,omitempty"`
+	RequesterEmail string `json:"requesterEmail,omitempty"`
+	CallbackURL    string `json:"callbackUrl,omitempty"`
+}
+
+type EnrollResponse struct {
+	SslId       int    `json:"sslId"`
+	OrderNumber string `json:"orderNumber"`
+	Subject     string `json:"subject"`
+	Message     string `json:"message"`
+	Warning     string `json:"warning,omitempty"`
+}
+
+// enrollResponse also decodes failures reported with HTTP 200, which the
+// API sometimes answers with.
+type enrollResponse struct {
+	EnrollResponse
+	Code int `json:"code"`
+}
+
+// Enroll places an order and returns its sslId.
+func (c *Client) Enroll(ctx context.Context, req EnrollRequest) (*EnrollResponse, error) {
+	var resp enrollResponse
+	if err := c.do(ctx, http.MethodPost, "/api/ssl/v1/enroll", req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.SslId == 0 {
+		return nil, &Error{StatusCode: http.StatusOK, Code: resp.Code, Message: resp.Message}
+	}
+	return &resp.EnrollResponse, nil
+}
+
+// Status is the state of an order.
+type Status struct {
+	SslId          int       `json:"sslId"`
+	OrderNumber    string    `json:"orderNumber"`
+	Status         string    `json:"status"` // e.g. "pending", "issued", "revoked", "expired"
+	CommonName     string    `json:"commonName"`
+	SANs           []string  `json:"sans"`
+	RequesterEmail string    `json:"requesterEmail,omitempty"`
+	RenewedFrom    int       `json:"renewedFrom,omitempty"`
+	ExpiresAt      time.Time `json:"expiresAt,omitzero"`
+}
+
+// Status fetches the state of order id.
+func (c *Client) Status(ctx context.Context, id int) (*Status, error) {
+	var resp Status
+	if err := c.do(ctx, http.MethodGet, "/api/ssl/v1/status/"+strconv.Itoa(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Collect downloads the certificate of issued order id in format, e.g.
+// "x509" for the PEM leaf and chain or "pkcs7"; "" is the server's
+// default.
+func (c *Client) Collect(ctx context.Context, id int, format string) ([]byte, error) {
+	path := "/api/ssl/v1/collect/" + strconv.Itoa(id)
+	if format != "" {
+		path += "?format=" + url.QueryEscape(format)
+	}
+	var body []byte
+	if err := c.do(ctx, http.MethodGet, path, nil, &body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+type revokeRequest struct {
+	SslId  string `json:"sslId"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type revokeResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Revoke revokes order id with an RFC 5280 reason such as
+// "keyCompromise", or "" for unspecified.
+func (c *Client) Revoke(ctx context.Context, id int, reason string) error {
+	var resp revokeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/ssl/v1/revoke", revokeRequest{strconv.Itoa(id), reason}, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "success" {
+		return &Error{StatusCode: http.StatusOK, Message: resp.Message}
+	}
+	return nil
+}
+
+// do sends in as the JSON body, if not nil, and decodes a successful
+// response into out, or copies it when out is a *[]byte. Other responses
+// become an *Error.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := c.Token(); token != "" {
+		req.Header.Set(tokenHeader, token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp.StatusCode, data)
+	}
+	if b, ok := out.(*[]byte); ok {
+		*b = data
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("sectigo: decoding %s response: %w", path, err)
+	}
+	return nil
+}
+
+// responseError decodes an error body: a Sectigo {"code","message"}
+// object, an RFC 7807 problem document or a revoke failure.
+func responseError(status int, data []byte) error {
+	var body struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Title   string `json:"title"`
+		Detail  string `json:"detail"`
+	}
+	e := &Error{StatusCode: status}
+	if json.Unmarshal(data, &body) == nil {
+		e.Code = body.Code
+		e.Message = body.Message
+		if e.Message == "" {
+			e.Message = body.Detail
+		}
+		if e.Message == "" {
+			e.Message = body.Title
+		}
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(status)
+	}
+	return e
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Certificate Revocation List ---
+
+// crlReasonCodes are the RFC 5280 CRLReason values of revocationReasons.
+var crlReasonCodes = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	reasonSuperseded:       4,
+	"cessationOfOperation": 5,
+	reasonCertificateHold:  6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// crlState is the last CRL signed. It is only re-signed, with the next
+// CRL number, when the revoked serials change or NextUpdate has passed,
+// so clients can tell a newer CRL by its number. Numbers restart with the
+// process, as does the CA that signs them.
+type crlState struct {
+	mu         sync.Mutex
+	number     int64
+	entries    string // Fingerprint of the entries signed, see crlEntriesLocked
+	thisUpdate time.Time
+	nextUpdate time.Time
+	der        []byte
+}
+
+// CRLInfo describes the current CRL.
+type CRLInfo struct {
+	CRLNumber  int64     `json:"crlNumber"`
+	ThisUpdate time.Time `json:"thisUpdate"`
+	NextUpdate time.Time `json:"nextUpdate"`
+}
+
+// crlEntriesLocked lists the revoked and held certificates, and those
+// replaced by reissue and revoked as superseded, sorted by serial.
+// Revocations younger than -revocation-lag at now are left out, as if
+// they had not propagated yet. mu must be held.
+func (s *Server) crlEntriesLocked(now time.Time) []x509.RevocationListEntry {
+	var entries []x509.RevocationListEntry
+	add := func(serialHex, reason string, at time.Time) {
+		serial, ok := new(big.Int).SetString(serialHex, 16)
+		if !ok || now.Before(at.Add(s.RevocationLag)) {
+			return
+		}
+		if reason == "" {
+			reason = "unspecified"
+		}
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: at, ReasonCode: crlReasonCodes[reason]})
+	}
+	for _, o := range s.orders {
+		for _, rc := range o.SupersededRevoked {
+			add(rc.Serial, reasonSuperseded, rc.RevokedAt)
+		}
+		if o.Status != "revoked" && o.Status != "held" {
+			continue
+		}
+		if serial, ok := certSerial(o.Certificate); ok {
+			add(serial, o.RevokeReason, o.RevokedAt)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SerialNumber.Cmp(entries[j].SerialNumber) < 0 })
+	return entries
+}
+
+// currentCRL returns the CRL valid at now, signing a new one if needed.
+func (s *Server) currentCRL(now time.Time) (CRLInfo, []byte, error) {
+	s.mu.RLock()
+	entries := s.crlEntriesLocked(now)
+	s.mu.RUnlock()
+
+	var fp strings.Builder
+	for _, e := range entries {
+		fp.WriteString(e.SerialNumber.Text(16) + "/" + strconv.Itoa(e.ReasonCode) + "/" + strconv.FormatInt(e.RevocationTime.UnixNano(), 10) + ";")
+	}
+
+	c := &s.crl
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.der == nil || c.entries != fp.String() || !now.Before(c.nextUpdate) {
+		thisUpdate := now.Truncate(time.Second)
+		der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:                    big.NewInt(c.number + 1),
+			ThisUpdate:                thisUpdate,
+			NextUpdate:                thisUpdate.Add(s.CRLValidity),
+			RevokedCertificateEntries: entries,
+		}, s.ca.issuer, s.ca.issuerKey)
+		if err != nil {
+			return CRLInfo{}, nil, err
+		}
+		c.number++
+		c.entries = fp.String()
+		c.thisUpdate, c.nextUpdate = thisUpdate, thisUpdate.Add(s.CRLValidity)
+		c.der = der
+	}
+	return CRLInfo{CRLNumber: c.number, ThisUpdate: c.thisUpdate, NextUpdate: c.nextUpdate}, c.der, nil
+}
+
+// handleCRL serves the CRL of the CA that signs leaves, as DER or, with
+// ?format=pem, PEM.
+func (s *Server) handleCRL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "der" && format != "pem" {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unsupported format: "+format+" (want pem or der)")
+		return
+	}
+	_, der, err := s.currentCRL(time.Now())
+	if err != nil {
+		log.Printf("[CRL] Signing: %v", err)
+		s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to sign CRL")
+		return
+	}
+
+	if format == "pem" {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"ca.crl.pem\"")
+		s.setCacheControl(w)
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"ca.crl\"")
+	s.setCacheControl(w)
+	w.Write(der)
+}
+
+// handleCRLSigner serves the certificate that signs the CRL, as PEM or,
+// with ?format=der, DER. Signing is not delegated: it is the CA that
+// signs leaves, the root or, under -chains, the intermediate.
+func (s *Server) handleCRLSigner(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"crl-signer.crt\"")
+		s.setCacheControl(w)
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.issuer.Raw}))
+	case "der":
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"crl-signer.cer\"")
+		s.setCacheControl(w)
+		w.Write(s.ca.issuer.Raw)
+	default:
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unsupported format: "+format+" (want pem or der)")
+	}
+}
+
+// handleCRLInfo reports the number and validity of the current CRL,
+// signing a new one first if the revocations changed.
+func (s *Server) handleCRLInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	info, _, err := s.currentCRL(time.Now())
+	if err != nil {
+		log.Printf("[CRL] Signing: %v", err)
+		s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to sign CRL")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Domain Control Validation ---
+//
+// With -dcv, enrolled orders carry a challenge per domain and stay
+// pending validation until /api/ssl/v1/dcv/validate is called for them;
+// only then does issuance start. Challenges follow Sectigo's format:
+// hashes of the CSR plus a unique value.
+
+// statusPendingValidation is reported for orders waiting on DCV.
+const statusPendingValidation = "pending validation"
+
+// DCV methods accepted by the validate endpoint.
+var dcvMethods = []string{"http", "dns", "email"}
+
+// DCVChallenge tells the client how to prove control of one domain by
+// each method.
+type DCVChallenge struct {
+	Domain      string   `json:"domain"`
+	HTTPURL     string   `json:"httpUrl"`     // Serve HTTPContent here
+	HTTPContent string   `json:"httpContent"` // SHA-256, "sectigo.com" and the unique value, one per line
+	DNSName     string   `json:"dnsName"`     // Create a CNAME with this name...
+	DNSTarget   string   `json:"dnsTarget"`   // ...pointing here
+	Emails      []string `json:"emails"`      // Approver addresses for email validation
+}
+
+type DCVValidateRequest struct {
+	SslId  flexID `json:"sslId"`
+	Method string `json:"method"` // "http", "dns" or "email"
+}
+
+// DCVPendingOrder is an order still waiting on DCV, with the methods its
+// product allows and the challenges that complete it.
+type DCVPendingOrder struct {
+	SslId      int            `json:"sslId"`
+	CommonName string         `json:"commonName"`
+	Methods    []string       `json:"methods"`
+	DCV        []DCVChallenge `json:"dcv"`
+}
+
+type DCVValidateResponse struct {
+	SslId   int      `json:"sslId"`
+	Status  string   `json:"status"`
+	Method  string   `json:"method"`
+	Domains []string `json:"domains"`
+}
+
+// dcvChallenges derives the challenges for csrDER's domains: its DNS
+// SANs, plus a domain-like CN not among them. IP SANs cannot be
+// validated this way and get none. A wildcard is validated on its base
+// domain.
+func dcvChallenges(csrDER []byte, commonName string, sans []string) []DCVChallenge {
+	var domains []string
+	for _, name := range append([]string{commonName}, sans...) {
+		name = strings.TrimPrefix(name, "*.")
+		if name == "" || !strings.Contains(name, ".") || strings.Contains(name, "@") || net.ParseIP(name) != nil {
+			continue
+		}
+		if !slices.Contains(domains, name) {
+			domains = append(domains, name)
+		}
+	}
+
+	md5Sum := md5.Sum(csrDER)
+	shaSum := sha256.Sum256(csrDER)
+	md5Hex := strings.ToUpper(hex.EncodeToString(md5Sum[:]))
+	shaHex := strings.ToUpper(hex.EncodeToString(shaSum[:]))
+	var unique [5]byte
+	rand.Read(unique[:])
+	uniqueValue := hex.EncodeToString(unique[:])
+
+	challenges := make([]DCVChallenge, 0, len(domains))
+	for _, d := range domains {
+		challenges = append(challenges, DCVChallenge{
+			Domain:      d,
+			HTTPURL:     "http://" + d + "/.well-known/pki-validation/" + md5Hex + ".txt",
+			HTTPContent: shaHex + "\nsectigo.com\n" + uniqueValue,
+			DNSName:     "_" + strings.ToLower(md5Hex) + "." + d,
+			DNSTarget:   strings.ToLower(shaHex[:32]) + "." + strings.ToLower(shaHex[32:]) + "." + uniqueValue + ".sectigo.com",
+			Emails: []string{
+				"admin@" + d, "administrator@" + d, "hostmaster@" + d, "postmaster@" + d, "webmaster@" + d,
+			},
+		})
+	}
+	return challenges
+}
+
+// handleDCVPending lists every order pending validation by ascending
+// sslId, so a client can fulfill all challenges in one sweep.
+func (s *Server) handleDCVPending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	s.mu.RLock()
+	pending := []DCVPendingOrder{}
+	for _, o := range s.orders {
+		if o.Status == "pending" && o.DCVPending {
+			pending = append(pending, DCVPendingOrder{
+				SslId:      o.ID,
+				CommonName: o.CommonName,
+				Methods:    s.allowedDCVMethods(o.ProductCode),
+				DCV:        o.DCV,
+			})
+		}
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(pending, func(a, b DCVPendingOrder) int { return a.SslId - b.SslId })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+}
+
+// handleDCVValidate marks an order's domains as validated by the given
+// method and starts its issuance, unless it still awaits manual approval.
+// Methods the order's product does not allow are rejected.
+// The mock does not fetch URLs, resolve records or send mail: every
+// well-formed request succeeds.
+func (s *Server) handleDCVValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	var req DCVValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	orderID, err := strconv.Atoi(string(req.SslId))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+	if !slices.Contains(dcvMethods, req.Method) {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unknown DCV method: "+req.Method)
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	var resp DCVValidateResponse
+	validated, disallowed := false, false
+	var allowed []string
+	if ok {
+		allowed = s.allowedDCVMethods(order.ProductCode)
+		disallowed = !slices.Contains(allowed, req.Method)
+		if !disallowed && order.Status == "pending" && order.DCVPending {
+			order.DCVPending = false
+			order.DCVMethod = req.Method
+			order.UpdatedAt = time.Now()
+			validated = true
+			if !order.AwaitingApproval {
+				s.startIssuanceLocked(order)
+			}
+			s.saveStoreLocked()
+		}
+		resp = DCVValidateResponse{SslId: orderID, Status: s.reportedStatus(order, time.Now()), Method: req.Method}
+		for _, c := range order.DCV {
+			resp.Domains = append(resp.Domains, c.Domain)
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if disallowed {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "DCV method not allowed for this product (allowed: "+strings.Join(allowed, ", ")+")")
+		return
+	}
+	if !validated {
+		s.writeError(w, http.StatusConflict, errCodeOrderState, "Order is not pending validation (status: "+resp.Status+")")
+		return
+	}
+	log.Printf("[DCV] Order %d validated by %s", orderID, req.Method)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// --- Error Responses ---
+
+const (
+	errorFormatPlain   = "plain"
+	errorFormatProblem = "problem"
+)
+
+// Sectigo error codes returned in the "code" field of error bodies.
+const (
+	errCodeInvalidRequest = -7   // Malformed body, parameter, header or method
+	errCodeUnknown        = -14  // Server-side failure or unavailable endpoint
+	errCodeUnauthorized   = -16  // Missing, unknown or expired session token
+	errCodeRateLimited    = -18  // Too many requests, see -rate-limit
+	errCodeNotFound       = -40  // Certificate (order) not found
+	errCodeInvalidCSR     = -103 // CSR missing, malformed or badly signed
+	errCodeOrderState     = -104 // Order is not in a state that allows the operation
+	errCodeEnrollFailed   = -105 // Enrollment rejected, no more specific code
+	errCodeInvalidProduct = -120 // Unknown product code or term it does not offer
+)
+
+// ProblemDetails is an RFC 7807 problem document. Code carries the Sectigo
+// error code as an extension member when one applies.
+type ProblemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   int    `json:"code,omitempty"`
+}
+
+// SectigoError is the JSON error body the Sectigo API returns.
+type SectigoError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// parseErrorStatuses parses comma-separated "code=status" pairs, e.g.
+// "-40=200,-103=422".
+func parseErrorStatuses(spec string) (map[int]int, error) {
+	m := make(map[int]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		codeStr, statusStr, ok := strings.Cut(entry, "=")
+		code, err1 := strconv.Atoi(strings.TrimSpace(codeStr))
+		status, err2 := strconv.Atoi(strings.TrimSpace(statusStr))
+		if !ok || err1 != nil || err2 != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("error status %q: want code=status", entry)
+		}
+		m[code] = status
+	}
+	return m, nil
+}
+
+// writeError sends a Sectigo-style {"code","message"} JSON body, or a
+// problem document carrying the code when -error-format=problem, with the
+// message localized to the language negotiated for w. The status may be
+// remapped per code with -error-status.
+func (s *Server) writeError(w http.ResponseWriter, status, code int, message string) {
+	if mapped, ok := s.ErrorStatuses[code]; ok {
+		status = mapped
+	}
+	lang := responseLanguage(w)
+	message = localize(lang, message)
+	w.Header().Set("Content-Language", lang)
+
+	if s.ErrorFormat == errorFormatProblem {
+		s.writeProblem(w, status, code, message)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SectigoError{Code: code, Message: message})
+}
+
+// handleNotFound answers paths no route matches, in the same error format
+// as an unknown order.
+func (s *Server) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	s.writeError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+}
+
+// EnrollError is the body of an enroll failure under -enroll-errors-200.
+type EnrollError struct {
+	SslId   int    `json:"sslId"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeEnrollError reports a rejected enrollment with code, or
+// errCodeEnrollFailed when code is 0. Normally that is an error response
+// with status. With -enroll-errors-200 it is HTTP 200 with sslId 0 and
+// the negative code, which clients can only tell apart from success by
+// reading the body.
+func (s *Server) writeEnrollError(w http.ResponseWriter, status, code int, message string) {
+	if code == 0 {
+		code = errCodeEnrollFailed
+	}
+	if !s.EnrollErrorsOK {
+		s.writeError(w, status, code, message)
+		return
+	}
+
+	lang := responseLanguage(w)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EnrollError{Code: code, Message: localize(lang, message)})
+}
+
+func (s *Server) writeProblem(w http.ResponseWriter, status, code int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ProblemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	})
+}
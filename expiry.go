@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// --- Certificate Expiry ---
+
+// validity returns how long days of certificate validity last. Days are
+// -day-length long, so shortening them speeds up expiry and renewal
+// windows alike for tests.
+func (s *Server) validity(days int) time.Duration {
+	return time.Duration(days) * s.DayLength
+}
+
+// certExpired reports whether o is issued or held with a certificate past
+// its NotAfter at now, whether or not the expirer has caught up yet.
+func certExpired(o *Order, now time.Time) bool {
+	return (o.Status == "issued" || o.Status == "held") && !o.ExpiresAt.IsZero() && !now.Before(o.ExpiresAt)
+}
+
+// expireOrdersLocked moves issued and held orders whose certificate has
+// passed its NotAfter by now to expired, and returns how many it moved.
+// mu must be held for writing.
+func (s *Server) expireOrdersLocked(now time.Time) int {
+	expired := 0
+	for _, o := range s.orders {
+		if !certExpired(o, now) || o.RevokePending {
+			continue
+		}
+		o.Status = "expired"
+		o.UpdatedAt = now
+		log.Printf("[Expiry] Order %d status changed to expired", o.ID)
+		s.notifyLocked(o)
+		expired++
+	}
+	if expired > 0 {
+		s.saveStoreLocked()
+	}
+	return expired
+}
+
+// runExpirer periodically applies expireOrdersLocked until the server is
+// closed. Until it gets to an order, status and collect already treat it
+// as expired; only listings may show it issued for up to one interval.
+func (s *Server) runExpirer() {
+	interval := s.DayLength / 10
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			s.expireOrdersLocked(now)
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
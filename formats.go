@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --- Collect Formats ---
+
+const maxPadBytes = 64 << 20 // Upper bound for collect's ?pad=
+
+// writePEMPadding appends n bytes of comment lines after the PEM blocks.
+// PEM decoders skip text outside BEGIN/END markers, so the certificate
+// still parses while the response grows to the requested size.
+func writePEMPadding(w http.ResponseWriter, n int) {
+	if n <= 0 {
+		return
+	}
+	line := "\n# padding " + strings.Repeat("x", 52)
+	for n > 0 {
+		chunk := line
+		if n < len(chunk) {
+			chunk = chunk[:n]
+		}
+		w.Write([]byte(chunk))
+		n -= len(chunk)
+	}
+}
+
+// writeTarBundle streams the certificate as a tarball laid out like an
+// ACME client's output: cert.pem, chain.pem and fullchain.pem. chain.pem
+// holds the selected -chains intermediate; without -chains leaves are
+// signed directly by the root from /api/ssl/v1/ca and it is empty.
+func writeTarBundle(w http.ResponseWriter, orderID int, order *Order, chainPEM string) {
+	cert := []byte(order.Certificate)
+	chain := []byte(chainPEM)
+	fullchain := append(append([]byte(nil), cert...), chain...)
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%d.tar\"", orderID))
+
+	tw := tar.NewWriter(w)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"cert.pem", cert},
+		{"chain.pem", chain},
+		{"fullchain.pem", fullchain},
+	}
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0o644,
+			Size:    int64(len(f.data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			log.Printf("[Collect] tar header for order %d: %v", orderID, err)
+			return
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			log.Printf("[Collect] tar write for order %d: %v", orderID, err)
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		log.Printf("[Collect] tar close for order %d: %v", orderID, err)
+	}
+}
+
+var (
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+)
+
+// writePKCS7 sends the certificates in pemCerts as a degenerate,
+// certificates-only PKCS#7 SignedData: DER for pkcs7, or PEM-armored for
+// base64.
+func (s *Server) writePKCS7(w http.ResponseWriter, orderID int, pemCerts string, armored bool) {
+	der, err := pkcs7CertsOnly(pemCerts)
+	if err != nil {
+		log.Printf("[Collect] pkcs7 for order %d: %v", orderID, err)
+		s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to encode PKCS#7")
+		return
+	}
+	if armored {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%d.p7b\"", orderID))
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: der}))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkcs7-mime")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%d.p7b\"", orderID))
+	w.Write(der)
+}
+
+// pkcs7CertsOnly encodes the CERTIFICATE blocks of pemCerts as an RFC 2315
+// SignedData with no content and no signers.
+func pkcs7CertsOnly(pemCerts string) ([]byte, error) {
+	var certs []byte
+	rest := []byte(pemCerts)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, block.Bytes...)
+		}
+	}
+
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// gzipResponseWriter compresses the body and marks it with
+// Content-Encoding: gzip. Collect uses it on explicit request (?gzip=true)
+// regardless of Accept-Encoding.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw *gzip.Writer
+}
+
+func newGzipResponseWriter(w http.ResponseWriter) *gzipResponseWriter {
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	return &gzipResponseWriter{ResponseWriter: w, zw: gzip.NewWriter(w)}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) { return g.zw.Write(p) }
+
+func (g *gzipResponseWriter) Close() error { return g.zw.Close() }
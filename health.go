@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// --- Health Probes ---
+//
+// /healthz and /readyz let orchestrators wait for the mock without
+// touching the API. They are unauthenticated and never disabled or
+// subject to chaos.
+
+type HealthResponse struct {
+	Status        string  `json:"status"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	Orders        int     `json:"orders"`
+	Store         string  `json:"store,omitempty"` // "memory" or the -store-file path
+}
+
+// health reports uptime and the order count with status.
+func (s *Server) health(status string) HealthResponse {
+	s.mu.RLock()
+	n := len(s.orders)
+	s.mu.RUnlock()
+	return HealthResponse{
+		Status:        status,
+		UptimeSeconds: time.Since(s.startedAt).Round(time.Millisecond).Seconds(),
+		Orders:        n,
+	}
+}
+
+// handleHealthz answers 200 whenever the server accepts connections.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.health("ok"))
+}
+
+// handleReadyz answers 200 while the server is serving with its store,
+// and -store-file if set, loaded. NewServer loads the store before any
+// request can arrive, so in practice it fails only after Drain, while
+// shutdown finishes in-flight requests.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+	resp := s.health("ready")
+	resp.Store = "memory"
+	if s.StoreFile != "" {
+		resp.Store = s.StoreFile
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !s.ready.Load() {
+		resp.Status = "not ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
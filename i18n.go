@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Localized Error Messages ---
+
+const defaultLanguage = "en"
+
+// messageCatalog maps the English error messages used by the handlers to
+// their translations. A single %s stands for a dynamic part (an order
+// status, endpoint name, ...) that is carried over untranslated.
+// Messages missing from a language fall back to English.
+var messageCatalog = map[string]map[string]string{
+	"de": {
+		"Method not allowed":      "Methode nicht erlaubt",
+		"Invalid request body":    "Ungültiger Anfragekörper",
+		"Invalid Order ID format": "Ungültiges Format der Auftrags-ID",
+		"Order not found":         "Auftrag nicht gefunden",
+		"Not found":               "Nicht gefunden",
+		"Only issued certificates can be renewed (status: %s)":  "Nur ausgestellte Zertifikate können erneuert werden (Status: %s)",
+		"Invalid product or term":                               "Ungültiges Produkt oder ungültige Laufzeit",
+		"CSR signature uses SHA-1, which is no longer accepted": "Die CSR-Signatur verwendet SHA-1, das nicht mehr akzeptiert wird",
+		"Unauthorized":                                                     "Nicht autorisiert",
+		"Unknown chain: %s":                                                "Unbekannte Zertifikatskette: %s",
+		"No intermediate CA configured":                                    "Keine Zwischenzertifizierungsstelle konfiguriert",
+		"Failed to sign CRL":                                               "Sperrliste konnte nicht signiert werden",
+		"Failed to sign certificate":                                       "Zertifikat konnte nicht signiert werden",
+		"Domain control validation incomplete (method: %s)":                "Domänenvalidierung nicht abgeschlossen (Methode: %s)",
+		"DCV method not allowed for this product (allowed: %s)":            "DCV-Methode für dieses Produkt nicht erlaubt (erlaubt: %s)",
+		"Invalid or missing admin token":                                   "Ungültiges oder fehlendes Admin-Token",
+		"Certificate has expired":                                          "Zertifikat ist abgelaufen",
+		"Certificate not ready (status: %s)":                               "Zertifikat noch nicht bereit (Status: %s)",
+		"Unsupported format: %s":                                           "Nicht unterstütztes Format: %s",
+		"Unsupported format: %s (want pem or der)":                         "Nicht unterstütztes Format: %s (erlaubt: pem oder der)",
+		"Unsupported format: %s (want x509, x509CO, base64, pkcs7 or tar)": "Nicht unterstütztes Format: %s (erlaubt: x509, x509CO, base64, pkcs7 oder tar)",
+		"Endpoint %s is unavailable":                                       "Endpunkt %s ist nicht verfügbar",
+		"Request exceeded maximum duration":                                "Anfrage hat die maximale Dauer überschritten",
+		"Certificate is not on hold (status: %s)":                          "Zertifikat ist nicht ausgesetzt (Status: %s)",
+		"CSR is invalid":                                                   "CSR ist ungültig",
+		"Common name %s is not among the CSR's DNS SANs":                   "Der Common Name %s fehlt in den DNS-SANs des CSR",
+		"Unknown revocation reason: %s":                                    "Unbekannter Sperrgrund: %s",
+		"Invalid callbackUrl":                                              "Ungültige callbackUrl",
+		"Injected failure":                                                 "Eingeschleuster Fehler",
+		"Invalid %s header":                                                "Ungültiger %s-Header",
+		"Rate limit exceeded":                                              "Anfragelimit überschritten",
+		"Only issued certificates can be reissued (status: %s)":            "Nur ausgestellte Zertifikate können neu ausgestellt werden (Status: %s)",
+		"Certificate version not found":                                    "Zertifikatsversion nicht gefunden",
+		"Invalid version value":                                            "Ungültiger version-Wert",
+		"Unknown DCV method: %s":                                           "Unbekannte DCV-Methode: %s",
+		"Order is not pending validation (status: %s)":                     "Auftrag wartet nicht auf Validierung (Status: %s)",
+		"%s was already used with a different request body":                "%s wurde bereits mit einem anderen Anfragekörper verwendet",
+	},
+}
+
+// catalogPatterns lists the %s messages of each language, most specific
+// first: the longest fixed text, then alphabetically. Where several match,
+// as "Unsupported format: %s" and "Unsupported format: %s (want pem or
+// der)" both do, localize thus always picks the one translating the most.
+var catalogPatterns = func() map[string][]string {
+	patterns := make(map[string][]string, len(messageCatalog))
+	for lang, catalog := range messageCatalog {
+		var keys []string
+		for src := range catalog {
+			if strings.Contains(src, "%s") {
+				keys = append(keys, src)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) > len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		patterns[lang] = keys
+	}
+	return patterns
+}()
+
+// localize translates msg into lang using messageCatalog.
+func localize(lang, msg string) string {
+	catalog, ok := messageCatalog[lang]
+	if !ok {
+		return msg
+	}
+	if t, ok := catalog[msg]; ok {
+		return t
+	}
+	for _, src := range catalogPatterns[lang] {
+		prefix, suffix, _ := strings.Cut(src, "%s")
+		if len(msg) < len(prefix)+len(suffix) {
+			continue
+		}
+		if strings.HasPrefix(msg, prefix) && strings.HasSuffix(msg, suffix) {
+			arg := msg[len(prefix) : len(msg)-len(suffix)]
+			return strings.Replace(catalog[src], "%s", arg, 1)
+		}
+	}
+	return msg
+}
+
+// negotiateLanguage picks the best supported language from an
+// Accept-Language header, honoring q-values.
+func negotiateLanguage(header string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		candidates = append(candidates, candidate{base, q})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	for _, c := range candidates {
+		if c.q <= 0 {
+			continue
+		}
+		if c.lang == defaultLanguage {
+			return c.lang
+		}
+		if _, ok := messageCatalog[c.lang]; ok {
+			return c.lang
+		}
+	}
+	return defaultLanguage
+}
+
+// langWriter carries the negotiated language down to writeError.
+type langWriter struct {
+	http.ResponseWriter
+	lang string
+}
+
+func (lw *langWriter) Unwrap() http.ResponseWriter { return lw.ResponseWriter }
+
+// withLanguage negotiates the response language from Accept-Language.
+func withLanguage(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(*langWriter); !ok {
+			w = &langWriter{ResponseWriter: w, lang: negotiateLanguage(r.Header.Get("Accept-Language"))}
+		}
+		h(w, r)
+	}
+}
+
+// responseLanguage returns the language negotiated for w, if any.
+func responseLanguage(w http.ResponseWriter) string {
+	if lw, ok := w.(*langWriter); ok {
+		return lw.lang
+	}
+	return defaultLanguage
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"time"
+)
+
+// --- Idempotency Keys ---
+
+// idempotencyKeyHeader lets clients retry an enroll safely: requests with
+// the same key get the response of the first one instead of a new order.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayHeader marks responses replayed from the cache.
+const idempotentReplayHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLen bounds client-sent keys.
+const maxIdempotencyKeyLen = 255
+
+// idempotentResponse is the cached outcome of the first request with a
+// key. done is closed once status, header and body are filled in, so a
+// retry that races the original waits for it instead of enrolling twice.
+type idempotentResponse struct {
+	bodyHash  [sha256.Size]byte
+	done      chan struct{}
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time // Zero until done
+}
+
+// responseCapture passes a response through while keeping a copy of it.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rc *responseCapture) WriteHeader(code int) {
+	if rc.status == 0 {
+		rc.status = code
+	}
+	rc.ResponseWriter.WriteHeader(code)
+}
+
+func (rc *responseCapture) Write(p []byte) (int, error) {
+	if rc.status == 0 {
+		rc.status = http.StatusOK
+	}
+	rc.body.Write(p)
+	return rc.ResponseWriter.Write(p)
+}
+
+// withIdempotencyKey runs h at most once per Idempotency-Key within
+// IdempotencyTTL and replays its response to later requests with that
+// key. Reusing a key with a different body is rejected with 409. Requests
+// without the header are passed through unchanged.
+func (s *Server) withIdempotencyKey(w http.ResponseWriter, r *http.Request, h http.HandlerFunc) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		h(w, r)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid "+idempotencyKeyHeader+" header")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEnrollFormBytes))
+	if err != nil {
+		s.writeEnrollError(w, http.StatusBadRequest, 0, "Invalid request body")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	hash := sha256.Sum256(body)
+
+	s.mu.Lock()
+	now := time.Now()
+	for k, e := range s.idempotent {
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			delete(s.idempotent, k)
+		}
+	}
+	cached, ok := s.idempotent[key]
+	if !ok {
+		cached = &idempotentResponse{bodyHash: hash, done: make(chan struct{})}
+		s.idempotent[key] = cached
+	}
+	s.mu.Unlock()
+
+	if ok {
+		if cached.bodyHash != hash {
+			s.writeError(w, http.StatusConflict, errCodeInvalidRequest, idempotencyKeyHeader+" was already used with a different request body")
+			return
+		}
+		select {
+		case <-cached.done:
+		case <-r.Context().Done():
+			return
+		}
+		dst := w.Header()
+		for k, v := range cached.header {
+			if k != requestIDHeader {
+				dst[k] = v
+			}
+		}
+		dst.Set(idempotentReplayHeader, "true")
+		w.WriteHeader(cached.status)
+		w.Write(cached.body)
+		return
+	}
+
+	rc := &responseCapture{ResponseWriter: w}
+	h(rc, r)
+	if rc.status == 0 {
+		rc.status = http.StatusOK
+	}
+
+	s.mu.Lock()
+	cached.status = rc.status
+	cached.header = w.Header().Clone()
+	cached.body = rc.body.Bytes()
+	cached.expiresAt = time.Now().Add(s.IdempotencyTTL)
+	s.mu.Unlock()
+	close(cached.done)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+)
+
+// --- Listener ---
+
+// perIPLimitListener caps the number of concurrently open connections
+// from each remote IP. Excess connections are closed right after accept,
+// which clients observe as a reset or EOF.
+type perIPLimitListener struct {
+	net.Listener
+	max int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func newPerIPLimitListener(l net.Listener, max int) *perIPLimitListener {
+	return &perIPLimitListener{Listener: l, max: max, conns: make(map[string]int)}
+}
+
+func (l *perIPLimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(c)
+		l.mu.Lock()
+		if l.conns[ip] >= l.max {
+			l.mu.Unlock()
+			log.Printf("[Conn] Rejecting connection from %s: limit of %d reached", ip, l.max)
+			c.Close()
+			continue
+		}
+		l.conns[ip]++
+		l.mu.Unlock()
+
+		return &trackedConn{Conn: c, release: func() { l.release(ip) }}, nil
+	}
+}
+
+func (l *perIPLimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// trackedConn gives its slot back to the listener when closed.
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+func remoteIP(c net.Conn) string {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// --- Log Ring Buffer ---
+
+// logRing keeps the most recent log lines in memory and fans new lines out
+// to any live subscribers. It is installed as an extra log output so the
+// admin logs endpoint can replay and tail server logs.
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+	subs  map[chan string]struct{}
+}
+
+func newLogRing(size int) *logRing {
+	if size < 1 {
+		size = 1
+	}
+	return &logRing{
+		lines: make([]string, size),
+		subs:  make(map[chan string]struct{}),
+	}
+}
+
+// Write implements io.Writer. The log package issues one Write per entry.
+func (l *logRing) Write(p []byte) (int, error) {
+	text := strings.TrimRight(string(p), "\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range strings.Split(text, "\n") {
+		l.lines[l.next] = line
+		l.next = (l.next + 1) % len(l.lines)
+		if l.next == 0 {
+			l.full = true
+		}
+		for ch := range l.subs {
+			// Drop lines for slow readers rather than block logging.
+			select {
+			case ch <- line:
+			default:
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// snapshotLocked returns the buffered lines, oldest first. l.mu must be held.
+func (l *logRing) snapshotLocked() []string {
+	if !l.full {
+		return append([]string(nil), l.lines[:l.next]...)
+	}
+	out := make([]string, 0, len(l.lines))
+	out = append(out, l.lines[l.next:]...)
+	return append(out, l.lines[:l.next]...)
+}
+
+// subscribe returns the current backlog and a channel receiving new lines.
+// Taking both under one lock guarantees no line is missed or duplicated.
+func (l *logRing) subscribe() ([]string, chan string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch := make(chan string, 64)
+	l.subs[ch] = struct{}{}
+	return l.snapshotLocked(), ch
+}
+
+func (l *logRing) unsubscribe(ch chan string) {
+	l.mu.Lock()
+	delete(l.subs, ch)
+	l.mu.Unlock()
+}
+
+// logBuffer is replaced in main once -log-buffer-lines is known.
+var logBuffer = newLogRing(1000)
+
+// handleAdminLogs streams the buffered log lines followed by new ones as
+// Server-Sent Events until the client disconnects.
+func (s *Server) handleAdminLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Streaming unsupported")
+		return
+	}
+
+	backlog, ch := logBuffer.subscribe()
+	defer logBuffer.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, line := range backlog {
+		fmt.Fprintf(w, "data: %s\n\n", line)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-ch:
+			fmt.Fprintf(w, "data: %s\n\n", line)
+			flusher.Flush()
+		}
+	}
+}
@@ -1,14 +1,31 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/big"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 )
 
@@ -20,23 +37,43 @@ type AuthRequest struct {
 }
 
 type AuthResponse struct {
-	SslId   string `json:"sslId"`
-	Message string `json:"message"`
+	SslId     string `json:"sslId"`
+	Message   string `json:"message"`
+	ExpiresIn int    `json:"expiresIn,omitempty"` // Seconds until the token expires (-session-ttl)
 }
 
 type EnrollRequest struct {
-	Csr         string `json:"csr"`
-	Term        int    `json:"term"`
-	ProductCode int    `json:"productCode"`
+	Csr            string `json:"csr"`
+	Term           int    `json:"term"`
+	ProductCode    int    `json:"productCode"`
+	RequesterEmail string `json:"requesterEmail,omitempty"`
+	Chain          string `json:"chain,omitempty"`       // -chains variant collect returns by default
+	CallbackURL    string `json:"callbackUrl,omitempty"` // POSTed to when the order is issued or revoked
+
+	// IssuanceDelaySeconds overrides -issuance-delay for this order.
+	IssuanceDelaySeconds *int `json:"issuanceDelaySeconds,omitempty"`
 }
 
 type EnrollResponse struct {
-	SslId   int    `json:"sslId"`
-	Message string `json:"message"`
+	SslId       int    `json:"sslId"`
+	OrderNumber string `json:"orderNumber"`
+	Subject     string `json:"subject"` // Normalized subject the certificate will carry
+	Message     string `json:"message"`
+	Warning     string `json:"warning,omitempty"`
+	RenewedFrom int    `json:"renewedFrom,omitempty"`
+
+	DCV []DCVChallenge `json:"dcv,omitempty"` // Challenges to complete before issuance (-dcv)
+}
+
+// RenewRequest renews an issued order. Csr may be omitted to reuse the
+// original order's CSR.
+type RenewRequest struct {
+	SslId flexID `json:"sslId"`
+	Csr   string `json:"csr"`
 }
 
 type RevokeRequest struct {
-	SslId  string `json:"sslId"`
+	SslId  flexID `json:"sslId"`
 	Reason string `json:"reason"`
 }
 
@@ -45,128 +82,506 @@ type RevokeResponse struct {
 	Message string `json:"message"`
 }
 
+// flexID accepts an sslId sent as either a JSON string or number.
+type flexID string
+
+func (f *flexID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*f = flexID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*f = flexID(n.String())
+	return nil
+}
+
+type BulkRevokeRequest struct {
+	SslIds []flexID `json:"sslIds"`
+	Reason string   `json:"reason"`
+}
+
+type BulkRevokeResult struct {
+	SslId   string `json:"sslId"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type BulkRevokeResponse struct {
+	Results   []BulkRevokeResult `json:"results"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+}
+
+type Session struct {
+	Token     string
+	LoginName string
+	CreatedAt time.Time
+	ExpiresAt time.Time // Zero when -session-ttl is 0
+	Expired   bool      // Forced by the admin sessions endpoint
+}
+
+// validAt reports whether the session may still be used at now.
+func (s *Session) validAt(now time.Time) bool {
+	return !s.Expired && (s.ExpiresAt.IsZero() || now.Before(s.ExpiresAt))
+}
+
+// Injection is a canned response returned by status/collect for one order.
+type Injection struct {
+	StatusCode  int    `json:"statusCode"`
+	Body        string `json:"body"`
+	ContentType string `json:"contentType"`
+}
+
 type Order struct {
-	ID          int
-	CSR         string
-	Status      string // "pending", "issued", "revoked"
-	Certificate string // PEM content
-	CreatedAt   time.Time
+	ID            int       `json:"id"`
+	OrderNumber   string    `json:"orderNumber"`
+	CSR           string    `json:"csr"`
+	CommonName    string    `json:"commonName,omitempty"`  // Subject CN of the CSR
+	SANs          []string  `json:"sans,omitempty"`        // DNS, IP and email SANs of the CSR
+	Requester     string    `json:"requester,omitempty"`   // requesterEmail from enroll, if given
+	CallbackURL   string    `json:"callbackUrl,omitempty"` // Webhook from enroll, see notifyLocked
+	ProductCode   int       `json:"productCode"`
+	RequestedTerm int       `json:"requestedTerm"`         // Term bought, in days
+	Term          int       `json:"term"`                  // Validity in days, after clamping to the maximum validity
+	Chain         string    `json:"chain,omitempty"`       // -chains variant requested at enroll ("" = default)
+	RenewedFrom   int       `json:"renewedFrom,omitempty"` // ID of the order this one renews
+	Status        string    `json:"status"`                // "pending", "issued", "held", "revoked", "declined", "expired"
+	Certificate   string    `json:"certificate,omitempty"` // PEM leaf signed by the mock CA, set on issuance
+	CreatedAt     time.Time `json:"createdAt"`
+	IssuedAt      time.Time `json:"issuedAt,omitzero"`
+	ExpiresAt     time.Time `json:"expiresAt,omitzero"`    // NotAfter of Certificate; issued and held orders expire then
+	StatusPolls   int       `json:"statusPolls,omitempty"` // Number of status requests seen for this order; saved with the next change
+	PendingSince  time.Time `json:"pendingSince,omitzero"` // Enroll or latest reissue, see pendingSince
+
+	AwaitingApproval bool           `json:"awaitingApproval,omitempty"` // Pending until /admin/approve (-approval=manual)
+	UpdatedAt        time.Time      `json:"updatedAt"`                  // Last state change; status polls do not count
+	IssuanceDelay    *time.Duration `json:"issuanceDelay,omitempty"`    // Fixed at enroll; nil follows the flags, see issuanceTiming
+
+	DCV        []DCVChallenge `json:"dcv,omitempty"`
+	DCVPending bool           `json:"dcvPending,omitempty"` // Pending until /dcv/validate (-dcv)
+	DCVMethod  string         `json:"dcvMethod,omitempty"`  // How the domains were validated
+
+	ReissueCount         int           `json:"reissueCount,omitempty"`
+	PreviousCertificates []string      `json:"previousCertificates,omitempty"` // PEM leaves replaced by reissue, oldest first
+	SupersededRevoked    []RevokedCert `json:"supersededRevoked,omitempty"`    // Replaced leaves revoked as superseded (-reissue-revokes-superseded)
+
+	RevokeReason  string    `json:"revokeReason,omitempty"`
+	RevokedAt     time.Time `json:"revokedAt,omitzero"`
+	RevokePending bool      `json:"revokePending,omitempty"` // Accepted but not yet reflected in Status (-revoke-lag)
 }
 
-// --- In-Memory Store ---
+type RevocationSummary struct {
+	Total            int            `json:"total"`
+	ByReason         map[string]int `json:"byReason"`
+	MostRecentRevoke time.Time      `json:"mostRecentRevocation,omitzero"`
+}
 
-var (
-	orders  = make(map[int]*Order)
-	mu      sync.RWMutex
-	nextID  = 12345
+type RevokedEntry struct {
+	SslId     int       `json:"sslId"`
+	Serial    string    `json:"serial,omitempty"` // Hex serial of the revoked certificate
+	CN        string    `json:"cn"`
+	Reason    string    `json:"reason"`
+	RevokedAt time.Time `json:"revokedAt"`
+}
+
+const (
+	reasonCertificateHold = "certificateHold"
+	reasonSuperseded      = "superseded"
 )
 
+// revocationReasons are the RFC 5280 CRLReason names revoke accepts. An
+// empty reason means unspecified. removeFromCRL is not one: use unhold.
+var revocationReasons = []string{
+	"unspecified", "keyCompromise", "cACompromise", "affiliationChanged", reasonSuperseded,
+	"cessationOfOperation", reasonCertificateHold, "privilegeWithdrawn", "aACompromise",
+}
+
+// validRevocationReason reports whether revoke accepts reason.
+func validRevocationReason(reason string) bool {
+	return reason == "" || slices.Contains(revocationReasons, reason)
+}
+
 // --- Handlers ---
 
-func handleAuth(w http.ResponseWriter, r *http.Request) {
+// checkCSR parses a PEM CSR for enroll or reissue and applies
+// -reject-sha1 and -require-cn-in-sans. On failure it writes the error
+// and returns false.
+func (s *Server) checkCSR(w http.ResponseWriter, csrPEM string) (*x509.CertificateRequest, bool) {
+	csr, err := parseCSRPEM(csrPEM)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		log.Printf("[Enroll] Rejecting invalid CSR: %v", err)
+		s.writeEnrollError(w, http.StatusBadRequest, errCodeInvalidCSR, "CSR is invalid")
+		return nil, false
+	}
+	if s.RejectSHA1 && isSHA1Signature(csr.SignatureAlgorithm) {
+		log.Printf("[Enroll] Rejecting CSR signed with %s", csr.SignatureAlgorithm)
+		s.writeEnrollError(w, http.StatusBadRequest, errCodeInvalidCSR, "CSR signature uses SHA-1, which is no longer accepted")
+		return nil, false
+	}
+	if s.RequireCNInSANs {
+		if msg := checkCNInSANs(csr); msg != "" {
+			s.writeEnrollError(w, http.StatusBadRequest, 0, msg)
+			return nil, false
+		}
+	}
+	return csr, true
+}
+
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
 		return
 	}
 
 	var req AuthRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 		return
 	}
 
 	// Mock Validation: Allow everything for now, or check for specific values
 	// In a real scenario, check DB.
 	log.Printf("[Auth] User: %s", req.LoginName)
+	if s.DebugAuth {
+		log.Printf("[Auth] INSECURE debug-auth: loginName=%q password=%q", req.LoginName, req.Password)
+	}
+
+	if s.AuthDelay > 0 {
+		select {
+		case <-time.After(s.AuthDelay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	token := generateRandomSessionID()
+	now := time.Now()
+	sess := &Session{
+		Token:     token,
+		LoginName: req.LoginName,
+		CreatedAt: now,
+	}
+	if s.SessionTTL > 0 {
+		sess.ExpiresAt = now.Add(s.SessionTTL)
+	}
+	s.mu.Lock()
+	s.sessions[token] = sess
+	s.mu.Unlock()
 
 	resp := AuthResponse{
-		SslId:   generateRandomSessionID(),
-		Message: "Authentication successful",
+		SslId:     token,
+		Message:   "Authentication successful",
+		ExpiresIn: int(s.SessionTTL.Seconds()),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func handleEnroll(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleEnroll(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
 		return
 	}
 
-	// Check Auth Headers (Mock)
-	// token := r.Header.Get("token") ...
+	if !s.checkSession(w, r) {
+		return
+	}
 
-	var req EnrollRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	// The capturing writer hides the negotiated language; negotiate again.
+	s.withIdempotencyKey(w, r, withLanguage(func(w http.ResponseWriter, r *http.Request) {
+		req, err := decodeEnrollRequest(r)
+		if err != nil {
+			s.writeEnrollError(w, http.StatusBadRequest, 0, "Invalid request body")
+			return
+		}
+		s.enroll(w, req, 0)
+	}))
+}
+
+// enroll validates req and creates an order for it, answering with an
+// EnrollResponse. renewedFrom is the ID of the order being renewed, or 0.
+func (s *Server) enroll(w http.ResponseWriter, req EnrollRequest, renewedFrom int) {
+	csr, ok := s.checkCSR(w, req.Csr)
+	if !ok {
+		return
+	}
+	if req.RequesterEmail != "" {
+		if _, err := mail.ParseAddress(req.RequesterEmail); err != nil {
+			s.writeEnrollError(w, http.StatusBadRequest, 0, "Invalid requesterEmail")
+			return
+		}
+	}
+	if req.CallbackURL != "" && !validCallbackURL(req.CallbackURL) {
+		s.writeEnrollError(w, http.StatusBadRequest, 0, "Invalid callbackUrl")
+		return
+	}
+	if _, ok := s.ca.chain(req.Chain); !ok {
+		s.writeEnrollError(w, http.StatusBadRequest, 0, "Unknown chain: "+req.Chain)
+		return
+	}
+	if req.IssuanceDelaySeconds != nil && *req.IssuanceDelaySeconds < 0 {
+		s.writeEnrollError(w, http.StatusBadRequest, 0, "Invalid issuanceDelaySeconds")
+		return
+	}
+
+	rule := s.matchScenarioRule(csr)
+	if rule != nil && rule.Outcome == outcomeFail {
+		log.Printf("[Enroll] Rejected by scenario rule %q", rule.CN)
+		s.writeEnrollError(w, rule.StatusCode, 0, rule.Message)
 		return
 	}
 
-	mu.Lock()
-	orderID := nextID
-	nextID++
-	
-	// Create Mock Certificate immediately for simplicity, or wait for status check
-	cert := generateFakeCert()
+	product, requestedTerm, ok := s.lookupProduct(req.ProductCode, req.Term)
+	if !ok {
+		s.writeEnrollError(w, http.StatusBadRequest, errCodeInvalidProduct, "Invalid product or term")
+		return
+	}
+	term, maxDays := requestedTerm, product.MaxValidityDays
+	if s.MaxValidityDays > 0 && (maxDays == 0 || s.MaxValidityDays < maxDays) {
+		maxDays = s.MaxValidityDays
+	}
+	var warning string
+	if maxDays > 0 && term > maxDays {
+		warning = fmt.Sprintf("Requested term of %d days exceeds the maximum validity; clamped to %d days", requestedTerm, maxDays)
+		term = maxDays
+	}
 
-	orders[orderID] = &Order{
-		ID:          orderID,
-		CSR:         req.Csr,
-		Status:      "pending", // Start as pending, auto-approve later or immediately?
-		Certificate: cert,
-		CreatedAt:   time.Now(),
+	subject := normalizeSubject(csr.Subject)
+	if normalized := subject.String(); normalized != csr.Subject.String() {
+		log.Printf("[Enroll] Normalized subject %q to %q", csr.Subject.String(), normalized)
 	}
-	mu.Unlock()
 
-	// Simulate background issuance
-	go func(id int) {
-		time.Sleep(5 * time.Second) // Wait 5 seconds to simulate validation
-		mu.Lock()
-		if o, ok := orders[id]; ok {
-			o.Status = "issued"
-			log.Printf("[Enroll] Order %d status changed to issued", id)
+	// Issue after a delay unless issuance is driven by status polls. An
+	// explicit per-order delay or a scenario rule takes precedence.
+	delay, timed := s.IssuanceDelay, s.IssueAfterPolls == 0
+	if req.IssuanceDelaySeconds != nil {
+		delay, timed = time.Duration(*req.IssuanceDelaySeconds)*time.Second, true
+	}
+	if rule != nil {
+		timed = rule.Outcome == outcomeIssue
+		if rule.Delay != "" {
+			delay = rule.delay
 		}
-		mu.Unlock()
-	}(orderID)
+	}
+	// Kept on the order, so that approval and DCV release it on its own
+	// schedule rather than -issuance-delay.
+	baseTimed := timed
+	// In manual mode nothing is issued before an admin approves it.
+	awaiting := s.Approval == approvalManual && (rule == nil || rule.Outcome == outcomeIssue)
+	if awaiting {
+		timed = false
+	}
+	// With -dcv nothing is issued before its domains are validated.
+	var challenges []DCVChallenge
+	if s.DCV && (rule == nil || rule.Outcome == outcomeIssue) {
+		challenges = dcvChallenges(csr.Raw, subject.CommonName, csrSANs(csr, product.Template))
+	}
+	if len(challenges) > 0 {
+		timed = false
+	}
 
+	now := time.Now()
+	// Immediate issuance is signed before taking the lock: signing is
+	// the slowest part of enroll and would serialize parallel enrolls.
+	// Without a timer to retry it, a failure fails the enroll rather than
+	// leaving the order pending for good.
+	var presigned string
+	if timed && delay == 0 {
+		var err error
+		if presigned, err = s.ca.issue(csr, now, now.Add(s.validity(term)), product.Template); err != nil {
+			log.Printf("[Enroll] Signing certificate: %v", err)
+			s.writeEnrollError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to sign certificate")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	orderID := s.allocateOrderIDLocked()
+
+	s.orders[orderID] = &Order{
+		ID:            orderID,
+		OrderNumber:   formatOrderNumber(s.OrderNumberFormat, orderID, now),
+		CSR:           req.Csr,
+		CommonName:    subject.CommonName,
+		SANs:          csrSANs(csr, product.Template),
+		Requester:     req.RequesterEmail,
+		CallbackURL:   req.CallbackURL,
+		Chain:         req.Chain,
+		RenewedFrom:   renewedFrom,
+		ProductCode:   product.Code,
+		UpdatedAt:     now,
+		RequestedTerm: requestedTerm,
+		Term:          term,
+		Status:        "pending", // Start as pending, auto-approve later or immediately?
+		CreatedAt:     now,
+		PendingSince:  now,
+
+		AwaitingApproval: awaiting,
+		DCV:              challenges,
+		DCVPending:       len(challenges) > 0,
+	}
+	if baseTimed {
+		s.orders[orderID].IssuanceDelay = &delay
+	}
+	orderNumber := s.orders[orderID].OrderNumber
+	if rule != nil && rule.Outcome == outcomeRevoke && s.issueOrderLocked(orderID) {
+		target := "revoked"
+		if rule.Reason == reasonCertificateHold {
+			target = "held"
+		}
+		s.applyRevocationLocked(s.orders[orderID], target, rule.Reason)
+	}
+	if presigned != "" {
+		s.completeIssuanceLocked(s.orders[orderID], presigned, now)
+	}
+	if timed && delay > 0 {
+		// A timer rather than a sleeping goroutine: nothing is left
+		// running for orders still pending when the process exits.
+		s.scheduleLocked(delay, func() { s.issueOrderLocked(orderID) })
+	}
+	s.saveStoreLocked()
+	s.mu.Unlock()
+
+	if rule != nil {
+		log.Printf("[Enroll] Order %d follows scenario rule %q (%s)", orderID, rule.CN, rule.Outcome)
+	}
 	log.Printf("[Enroll] New Order ID: %d", orderID)
 
 	resp := EnrollResponse{
-		SslId:   orderID,
-		Message: "Order created successfully",
+		SslId:       orderID,
+		OrderNumber: orderNumber,
+		Subject:     subject.String(),
+		Message:     "Order created successfully",
+		Warning:     warning,
+		RenewedFrom: renewedFrom,
+		DCV:         challenges,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func handleStatus(w http.ResponseWriter, r *http.Request) {
+// handleRenew creates a new order for the same names as an issued one.
+// The new order records the original in RenewedFrom and goes through
+// issuance like a fresh enrollment, keeping the original's product,
+// term, requester and chain.
+func (s *Server) handleRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	var req RenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	origID, err := strconv.Atoi(string(req.SslId))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+
+	s.mu.RLock()
+	orig, ok := s.orders[origID]
+	var enrollReq EnrollRequest
+	var status string
+	if ok {
+		status = orig.Status
+		enrollReq = EnrollRequest{
+			Csr:            orig.CSR,
+			ProductCode:    orig.ProductCode,
+			Term:           orig.RequestedTerm,
+			RequesterEmail: orig.Requester,
+			Chain:          orig.Chain,
+			CallbackURL:    orig.CallbackURL,
+		}
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if status != "issued" {
+		s.writeError(w, http.StatusConflict, errCodeOrderState, "Only issued certificates can be renewed (status: "+status+")")
+		return
+	}
+	if req.Csr != "" {
+		enrollReq.Csr = req.Csr
+	}
+
+	log.Printf("[Renew] Renewing order %d", origID)
+	s.enroll(w, enrollReq, origID)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
 		return
 	}
 
-	pathParts := strings.Split(r.URL.Path, "/")
-	// /api/ssl/v1/status/{id} -> ["", "api", "ssl", "v1", "status", "{id}"]
-	if len(pathParts) < 6 {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
+	if !s.checkSession(w, r) {
 		return
 	}
-	idStr := pathParts[5] // The ID
-	var orderID int
-	_, err := fmt.Sscanf(idStr, "%d", &orderID)
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "Invalid Order ID format", http.StatusBadRequest)
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+
+	if s.serveInjected(w, orderID) {
 		return
 	}
 
-	mu.RLock()
-	order, ok := orders[orderID]
-	mu.RUnlock()
+	// Taken for writing: polls are counted for -issue-after-polls.
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	var orderNumber, status, requester, commonName, serial string
+	var sans, superseded []string
+	var renewedFrom int
+	var expiresAt time.Time
+	var awaiting bool
+	var challenges []DCVChallenge
+	if ok {
+		order.StatusPolls++
+		if s.IssueAfterPolls > 0 && order.StatusPolls >= s.IssueAfterPolls && !order.AwaitingApproval && !order.DCVPending {
+			s.issueOrderLocked(orderID) // Saves the store if it issues
+		}
+		// Polls alone are not saved: rewriting -store-file on every
+		// status request would make polling as slow as the disk.
+		orderNumber, status, requester = order.OrderNumber, s.reportedStatus(order, time.Now()), order.Requester
+		commonName, sans = order.CommonName, order.SANs
+		renewedFrom = order.RenewedFrom
+		expiresAt = order.ExpiresAt
+		awaiting = order.AwaitingApproval
+		serial, _ = certSerial(order.Certificate)
+		for _, prev := range order.PreviousCertificates {
+			if sn, ok := certSerial(prev); ok {
+				superseded = append(superseded, sn)
+			}
+		}
+		if order.DCVPending {
+			challenges = order.DCV
+		}
+	}
+	s.mu.Unlock()
 
 	if !ok {
-		http.Error(w, "Order not found", http.StatusNotFound)
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
 		return
 	}
 
@@ -174,123 +589,1125 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 	// Real Sectigo API might return JSON with status field.
 	w.Header().Set("Content-Type", "application/json")
 	// Returning a map for flexibility
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"sslId": orderID,
-		"status": order.Status,
-	})
+	resp := map[string]interface{}{
+		"sslId":       orderID,
+		"orderNumber": orderNumber,
+		"status":      status,
+		"commonName":  commonName,
+		"sans":        sans,
+	}
+	if requester != "" {
+		resp["requesterEmail"] = requester
+	}
+	if renewedFrom != 0 {
+		resp["renewedFrom"] = renewedFrom
+	}
+	if !expiresAt.IsZero() {
+		resp["expiresAt"] = expiresAt
+	}
+	if serial != "" {
+		resp["serialNumber"] = serial
+	}
+	if superseded != nil {
+		resp["supersededSerials"] = superseded
+	}
+	if awaiting {
+		resp["awaitingApproval"] = true
+	}
+	if challenges != nil {
+		resp["dcv"] = challenges
+	}
+	s.setCacheControl(w)
+	json.NewEncoder(w).Encode(resp)
 }
 
-func handleCollect(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleCollect(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
 		return
 	}
 
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 6 {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
+	if !s.checkSession(w, r) {
 		return
 	}
-	idStr := pathParts[5] // The ID
-	var orderID int
-	_, err := fmt.Sscanf(idStr, "%d", &orderID)
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "Invalid Order ID format", http.StatusBadRequest)
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "x509", "x509CO", "base64", "pkcs7", "tar":
+	default:
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unsupported format: "+format+" (want x509, x509CO, base64, pkcs7 or tar)")
+		return
+	}
+
+	var pad int
+	if v := r.URL.Query().Get("pad"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxPadBytes {
+			s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid pad value")
+			return
+		}
+		pad = n
+	}
+
+	var gzipped bool
+	if v := r.URL.Query().Get("gzip"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid gzip value")
+			return
+		}
+		gzipped = b
+	}
+
+	var version int
+	if v := r.URL.Query().Get("version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid version value")
+			return
+		}
+		version = n
+	}
+
+	if s.serveInjected(w, orderID) {
 		return
 	}
 
-	mu.RLock()
-	order, ok := orders[orderID]
-	mu.RUnlock()
+	s.mu.RLock()
+	var order Order
+	o, ok := s.orders[orderID]
+	if ok {
+		order = *o
+	}
+	s.mu.RUnlock()
 
 	if !ok {
-		http.Error(w, "Order not found", http.StatusNotFound)
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+
+	// ?version= picks a certificate replaced by reissue, 1 being the
+	// first; those stay collectable whatever the order's status.
+	previous := version > 0 && version <= len(order.PreviousCertificates)
+	if version > len(order.PreviousCertificates)+1 {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Certificate version not found")
+		return
+	}
+	if previous {
+		order.Certificate = order.PreviousCertificates[version-1]
+	} else if order.Status == "expired" || certExpired(&order, time.Now()) {
+		s.writeError(w, http.StatusBadRequest, errCodeOrderState, "Certificate has expired")
+		return
+	} else if order.Status == "pending" && order.DCVPending {
+		// Waiting on the client rather than on the CA, unlike the error below.
+		s.writeError(w, http.StatusBadRequest, errCodeOrderState, "Domain control validation incomplete (method: "+strings.Join(s.allowedDCVMethods(order.ProductCode), ", ")+")")
+		return
+	} else if order.Status != "issued" {
+		s.writeError(w, http.StatusBadRequest, errCodeOrderState, "Certificate not ready (status: "+order.Status+")")
 		return
 	}
 
-	if order.Status != "issued" {
-		http.Error(w, "Certificate not ready (status: "+order.Status+")", http.StatusBadRequest)
+	// Status may already say issued while the certificate is not yet
+	// collectable, as observed with the real CA.
+	if wait := s.CollectLag - time.Since(order.IssuedAt); wait > 0 && !previous {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		s.writeError(w, http.StatusBadRequest, errCodeOrderState, "Certificate not ready (status: "+order.Status+")")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/x-pem-file")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%d.crt\"", orderID))
-	w.Write([]byte(order.Certificate))
+	chainName := order.Chain
+	if v := r.URL.Query().Get("chain"); v != "" {
+		chainName = v
+	}
+	chainPEM, rootPEM := "", s.ca.certPEM
+	if ch, ok := s.ca.chain(chainName); !ok {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unknown chain: "+chainName)
+		return
+	} else if ch != nil {
+		chainPEM, rootPEM = ch.intermediatePEM, ch.rootPEM
+	}
+
+	if gzipped {
+		gw := newGzipResponseWriter(w)
+		defer gw.Close()
+		w = gw
+	}
+
+	// Sectigo's format names: x509CO is the leaf alone, x509 the leaf with
+	// its full chain, base64 and pkcs7 a PKCS#7 bundle of the same.
+	fullPEM := order.Certificate + chainPEM + rootPEM
+	switch format {
+	case "tar":
+		writeTarBundle(w, orderID, &order, chainPEM)
+	case "pkcs7", "base64":
+		s.writePKCS7(w, orderID, fullPEM, format == "base64")
+	default:
+		body := order.Certificate + chainPEM
+		switch format {
+		case "x509CO":
+			body = order.Certificate
+		case "x509":
+			body = fullPEM
+		}
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%d.crt\"", orderID))
+		w.Write([]byte(body))
+		writePEMPadding(w, pad)
+	}
 }
 
-func handleRevoke(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
 		return
 	}
 
 	var req RevokeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	if !validRevocationReason(req.Reason) {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unknown revocation reason: "+req.Reason)
 		return
 	}
 
-	var orderID int
-	_, err := fmt.Sscanf(req.SslId, "%d", &orderID)
-	
-	// Handle string fake ID if scan fails, maybe just log it
+	s.mu.Lock()
+	resp, status := s.revokeOrderLocked(string(req.SslId), req.Reason)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// revokeOrderLocked applies a revocation to the order identified by sslId
+// and describes the outcome along with the HTTP status a single revoke
+// answers with. mu must be held for writing.
+func (s *Server) revokeOrderLocked(sslId, reason string) (RevokeResponse, int) {
+	fail := func(status int, msg string) (RevokeResponse, int) {
+		return RevokeResponse{Status: "failure", Message: msg}, status
+	}
+	orderID, err := strconv.Atoi(sslId)
 	if err != nil {
-		// Try to see if it's our int ID
-		// In real usage, maybe check both
+		return fail(http.StatusBadRequest, "Invalid Order ID format")
+	}
+	o, ok := s.orders[orderID]
+	if !ok {
+		return fail(http.StatusNotFound, "Order not found")
+	}
+
+	hold := reason == reasonCertificateHold
+	switch {
+	case o.RevokePending:
+		return fail(http.StatusConflict, "Revocation already in progress")
+	case o.Status == "revoked" && hold:
+		return fail(http.StatusConflict, "Certificate is permanently revoked and cannot be put on hold")
+	case o.Status == "revoked":
+		return fail(http.StatusConflict, "Certificate is already revoked")
+	case o.Status == "held" && hold:
+		return fail(http.StatusConflict, "Certificate is already on hold")
+	case o.Status == "expired":
+		return fail(http.StatusConflict, "Certificate has expired")
+	case o.Status != "issued" && o.Status != "held":
+		// Nothing to revoke yet, and a held pending order would never issue.
+		return fail(http.StatusConflict, "Certificate not issued")
+	}
+
+	// certificateHold is the one reversible reason (RFC 5280), so it gets
+	// its own status that unhold can undo.
+	target := "revoked"
+	if hold {
+		target = "held"
+	}
+	if s.RevokeLag > 0 {
+		// Report success now but let the status catch up later.
+		o.RevokePending = true
+		o.RevokeReason = reason // Kept so -store-file can finish the revocation
+		o.UpdatedAt = time.Now()
+		s.saveStoreLocked()
+		s.scheduleLocked(s.RevokeLag, func() {
+			if o.RevokePending {
+				s.applyRevocationLocked(o, target, reason)
+			}
+		})
+	} else {
+		s.applyRevocationLocked(o, target, reason)
+	}
+
+	if hold {
+		return RevokeResponse{Status: "success", Message: "Certificate placed on hold"}, http.StatusOK
+	}
+	return RevokeResponse{Status: "success", Message: "Certificate revoked"}, http.StatusOK
+}
+
+// applyRevocationLocked moves o to the revoked or held status. mu must be
+// held for writing.
+func (s *Server) applyRevocationLocked(o *Order, status, reason string) {
+	o.RevokePending = false
+	o.Status = status
+	o.RevokeReason = reason
+	o.RevokedAt = time.Now()
+	o.UpdatedAt = o.RevokedAt
+	log.Printf("[Revoke] Order %d status changed to %s", o.ID, status)
+	if status == "revoked" {
+		s.notifyLocked(o)
+	}
+	s.saveStoreLocked()
+}
+
+func (s *Server) handleRevokeBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	var req BulkRevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if len(req.SslIds) == 0 {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "sslIds must not be empty")
+		return
+	}
+	if !validRevocationReason(req.Reason) {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unknown revocation reason: "+req.Reason)
+		return
+	}
+
+	resp := BulkRevokeResponse{Results: make([]BulkRevokeResult, 0, len(req.SslIds))}
+	s.mu.Lock()
+	for _, id := range req.SslIds {
+		res, _ := s.revokeOrderLocked(string(id), req.Reason)
+		if res.Status == "success" {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, BulkRevokeResult{
+			SslId:   string(id),
+			Status:  res.Status,
+			Message: res.Message,
+		})
+	}
+	s.mu.Unlock()
+
+	log.Printf("[Revoke] Bulk revoke: %d succeeded, %d failed", resp.Succeeded, resp.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleRevoked(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	s.mu.RLock()
+	entries := []RevokedEntry{}
+	for _, o := range s.orders {
+		for _, rc := range o.SupersededRevoked {
+			entries = append(entries, RevokedEntry{SslId: o.ID, Serial: rc.Serial, CN: rc.CN, Reason: reasonSuperseded, RevokedAt: rc.RevokedAt})
+		}
+		if o.Status == "revoked" || o.Status == "held" {
+			serial, _ := certSerial(o.Certificate)
+			entries = append(entries, RevokedEntry{
+				SslId:     o.ID,
+				Serial:    serial,
+				CN:        o.CommonName,
+				Reason:    o.RevokeReason,
+				RevokedAt: o.RevokedAt,
+			})
+		}
 	}
+	s.mu.RUnlock()
 
-	mu.Lock()
-	// Simple lookup
-	var found bool
-	for _, o := range orders {
-		// Mock logic: assuming req.SslId matches our int ID string representation
-		if fmt.Sprintf("%d", o.ID) == req.SslId {
-			o.Status = "revoked"
-			found = true
-			break
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].SslId != entries[j].SslId {
+			return entries[i].SslId < entries[j].SslId
 		}
+		return entries[i].RevokedAt.Before(entries[j].RevokedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handlePing is the cheapest possible liveness probe. It deliberately
+// touches no shared state and takes no locks.
+func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
 	}
-	mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("pong"))
+}
 
-	resp := RevokeResponse{
-		Status:  "success",
-		Message: "Certificate revoked",
+// serviceComponents are the subsystems reported by the service status
+// endpoint.
+var serviceComponents = []string{"signer", "store", "ocsp"}
+
+type ServiceStatus struct {
+	Status     string            `json:"status"` // "ok" or "degraded"
+	Components map[string]string `json:"components"`
+}
+
+// handleServiceStatus reports the health of the CA's subsystems. Those
+// named in -degraded-components report degraded; the service as a whole
+// is degraded when any of them is, but still answers 200.
+func (s *Server) handleServiceStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
 	}
-	if !found {
-		resp.Status = "failure"
-		resp.Message = "Order not found"
+
+	resp := ServiceStatus{Status: "ok", Components: make(map[string]string, len(serviceComponents))}
+	for _, c := range serviceComponents {
+		resp.Components[c] = "ok"
+		if s.DegradedComponents[c] {
+			resp.Components[c] = "degraded"
+			resp.Status = "degraded"
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+func (s *Server) handleRevocationSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	summary := RevocationSummary{ByReason: make(map[string]int)}
+	s.mu.RLock()
+	for _, o := range s.orders {
+		for _, rc := range o.SupersededRevoked {
+			summary.Total++
+			summary.ByReason[reasonSuperseded]++
+			if rc.RevokedAt.After(summary.MostRecentRevoke) {
+				summary.MostRecentRevoke = rc.RevokedAt
+			}
+		}
+		if o.Status != "revoked" && o.Status != "held" {
+			continue
+		}
+		reason := o.RevokeReason
+		if reason == "" {
+			reason = "unspecified"
+		}
+		summary.Total++
+		summary.ByReason[reason]++
+		if o.RevokedAt.After(summary.MostRecentRevoke) {
+			summary.MostRecentRevoke = o.RevokedAt
+		}
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// handleUnhold releases a certificate from certificateHold, returning it
+// to issued.
+func (s *Server) handleUnhold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	var status string
+	if ok {
+		status = order.Status
+		if status == "held" {
+			order.Status = "issued"
+			order.RevokeReason = ""
+			order.RevokedAt = time.Time{}
+			order.UpdatedAt = time.Now()
+			s.saveStoreLocked()
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if status != "held" {
+		s.writeError(w, http.StatusConflict, errCodeOrderState, "Certificate is not on hold (status: "+status+")")
+		return
+	}
+
+	log.Printf("[Unhold] Order %d released from hold", orderID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sslId":  orderID,
+		"status": "issued",
+	})
+}
+
 // --- Helpers ---
 
+const maxEnrollFormBytes = 1 << 20
+
+// decodeEnrollRequest reads an enroll request from either a JSON body or,
+// when the Content-Type says so, multipart/form-data with a "csr" file part
+// and "term"/"productCode"/"requesterEmail"/"chain"/"callbackUrl"/
+// "issuanceDelaySeconds" fields.
+func decodeEnrollRequest(r *http.Request) (EnrollRequest, error) {
+	var req EnrollRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		return req, err
+	}
+
+	if err := r.ParseMultipartForm(maxEnrollFormBytes); err != nil {
+		return req, err
+	}
+	if f, _, err := r.FormFile("csr"); err == nil {
+		defer f.Close()
+		b, err := io.ReadAll(io.LimitReader(f, maxEnrollFormBytes))
+		if err != nil {
+			return req, err
+		}
+		req.Csr = string(b)
+	} else {
+		// Tolerate clients that send the CSR as a plain text field.
+		req.Csr = r.FormValue("csr")
+	}
+	if req.Csr == "" {
+		return req, errors.New("missing csr part")
+	}
+
+	req.RequesterEmail = r.FormValue("requesterEmail")
+	req.Chain = r.FormValue("chain")
+	req.CallbackURL = r.FormValue("callbackUrl")
+	if v := r.FormValue("issuanceDelaySeconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("invalid issuanceDelaySeconds: %w", err)
+		}
+		req.IssuanceDelaySeconds = &n
+	}
+	for _, field := range []struct {
+		name string
+		dst  *int
+	}{{"term", &req.Term}, {"productCode", &req.ProductCode}} {
+		if v := r.FormValue(field.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return req, fmt.Errorf("invalid %s: %w", field.name, err)
+			}
+			*field.dst = n
+		}
+	}
+	return req, nil
+}
+
+// Range of the IDs handed out under -random-ids.
+const (
+	randomIDMin = 10000000
+	randomIDMax = 99999999
+)
+
+// allocateOrderIDLocked returns the ID for a new order: the next
+// sequential one, or with -random-ids a random unused one in
+// [randomIDMin, randomIDMax]. mu must be held for writing.
+func (s *Server) allocateOrderIDLocked() int {
+	if !s.RandomIDs {
+		id := s.nextID
+		s.nextID++
+		return id
+	}
+	span := big.NewInt(randomIDMax - randomIDMin + 1)
+	for {
+		n, err := rand.Int(rand.Reader, span)
+		if err != nil {
+			log.Fatalf("generating order ID: %v", err)
+		}
+		if id := randomIDMin + int(n.Int64()); s.orders[id] == nil {
+			return id
+		}
+	}
+}
+
+// issueOrderLocked signs the certificate for a pending order, moves it to
+// issued and reports whether it did so. Orders that were revoked or
+// otherwise moved on are left alone. mu must be held for writing.
+func (s *Server) issueOrderLocked(id int) bool {
+	o, ok := s.orders[id]
+	if !ok || o.Status != "pending" {
+		return false
+	}
+	csr, err := parseCSRPEM(o.CSR)
+	if err != nil {
+		log.Printf("[Enroll] Order %d: %v", id, err)
+		return false
+	}
+	now := time.Now()
+	cert, err := s.ca.issue(csr, now, now.Add(s.validity(o.Term)), s.certTemplate(o.ProductCode))
+	if err != nil {
+		log.Printf("[Enroll] Order %d: signing certificate: %v", id, err)
+		return false
+	}
+	s.completeIssuanceLocked(o, cert, now)
+	return true
+}
+
+// issuanceTiming returns how long o waits once nothing else holds it
+// back, and false if status polls issue it instead (-issue-after-polls).
+// Orders enrolled before the delay was kept on them follow the flags.
+func (s *Server) issuanceTiming(o *Order) (time.Duration, bool) {
+	if o.IssuanceDelay != nil {
+		return *o.IssuanceDelay, true
+	}
+	return s.IssuanceDelay, s.IssueAfterPolls == 0
+}
+
+// startIssuanceLocked issues pending o now or schedules it, per
+// issuanceTiming, once approval and DCV no longer hold it back. Orders
+// issued by status polls are left to those. mu must be held for writing.
+func (s *Server) startIssuanceLocked(o *Order) {
+	delay, timed := s.issuanceTiming(o)
+	if !timed {
+		return
+	}
+	if delay == 0 {
+		s.issueOrderLocked(o.ID)
+		return
+	}
+	id := o.ID
+	s.scheduleLocked(delay, func() { s.issueOrderLocked(id) })
+}
+
+// pendingSince is when o last became pending: at enroll, or at its latest
+// reissue. Status progression and validation steps start over from it.
+// Orders stored before it was tracked fall back to CreatedAt.
+func (o *Order) pendingSince() time.Time {
+	if o.PendingSince.IsZero() {
+		return o.CreatedAt
+	}
+	return o.PendingSince
+}
+
+// completeIssuanceLocked moves pending o to issued with cert, signed at
+// now. mu must be held for writing.
+func (s *Server) completeIssuanceLocked(o *Order, cert string, now time.Time) {
+	o.Status = "issued"
+	o.AwaitingApproval = false // Admin issue overrides manual approval and DCV
+	o.DCVPending = false
+	o.IssuedAt = now
+	o.ExpiresAt = now.Add(s.validity(o.Term)) // The certificate's NotAfter
+	o.UpdatedAt = now
+	o.Certificate = cert
+	log.Printf("[Enroll] Order %d status changed to issued", o.ID)
+	s.notifyLocked(o)
+	s.saveStoreLocked()
+}
+
+// endpoint wraps a public API handler so it can be switched off with
+// -disable-endpoints, simulating partial CA API availability, bounded by
+// -max-request-duration, subject to chaos failures and, with
+// -allow-header-faults, X-Mock-Fail, and localizes its errors per
+// Accept-Language.
+func (s *Server) endpoint(name string, h http.HandlerFunc) http.HandlerFunc {
+	// Language is negotiated on the outside for our own errors and again
+	// inside the timeout, whose buffering writer replaces the caller's.
+	h = withLanguage(h)
+	if len(s.LatencySchedule) > 0 {
+		h = s.withScheduledLatency(h, s.LatencySchedule)
+	}
+	if s.MaxRequestDuration > 0 {
+		h = s.withTimeout(h, s.MaxRequestDuration)
+	}
+	return s.withMetrics(name, withLanguage(func(w http.ResponseWriter, r *http.Request) {
+		if s.DisabledEndpoints[name] {
+			s.writeError(w, s.DisabledStatus, errCodeUnknown, "Endpoint "+name+" is unavailable")
+			return
+		}
+		if !s.checkRateLimit(w, r) {
+			return
+		}
+		if s.AllowHeaderFaults {
+			f, ok := headerFault(r)
+			if !ok {
+				s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid "+mockFailHeader+" header")
+				return
+			}
+			if f != nil {
+				s.writeChaosFault(w, name, f)
+				return
+			}
+		}
+		if f := s.pickChaosFault(name, r); f != nil {
+			s.writeChaosFault(w, name, f)
+			return
+		}
+		h(w, r)
+	}))
+}
+
+// setCacheControl advertises -cache-max-age and
+// -cache-stale-while-revalidate on a successful response.
+func (s *Server) setCacheControl(w http.ResponseWriter) {
+	if s.CacheMaxAge <= 0 && s.CacheStale <= 0 {
+		return
+	}
+	v := fmt.Sprintf("max-age=%d", int(s.CacheMaxAge.Seconds()))
+	if s.CacheStale > 0 {
+		v += fmt.Sprintf(", stale-while-revalidate=%d", int(s.CacheStale.Seconds()))
+	}
+	w.Header().Set("Cache-Control", v)
+}
+
+// serveInjected writes the canned response registered for orderID via the
+// admin inject endpoint, if any, and reports whether it did so.
+func (s *Server) serveInjected(w http.ResponseWriter, orderID int) bool {
+	s.mu.RLock()
+	inj, ok := s.injected[orderID]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	log.Printf("[Inject] Returning injected %d for order %d", inj.StatusCode, orderID)
+	w.Header().Set("Content-Type", inj.ContentType)
+	w.WriteHeader(inj.StatusCode)
+	w.Write([]byte(inj.Body))
+	return true
+}
+
+// checkSession requires a live session token from the auth endpoint,
+// sent in the token header or as an Authorization bearer token. Missing,
+// unknown and expired tokens get 401.
+func (s *Server) checkSession(w http.ResponseWriter, r *http.Request) bool {
+	token := sessionToken(r)
+
+	s.mu.RLock()
+	sess, ok := s.sessions[token]
+	valid := ok && sess.validAt(time.Now())
+	s.mu.RUnlock()
+
+	if !valid {
+		s.writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return false
+	}
+	return true
+}
+
+// sessionToken returns the token a request authenticates with, from the
+// token header or a bearer Authorization header.
+func sessionToken(r *http.Request) string {
+	if token := r.Header.Get("token"); token != "" {
+		return token
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
 func generateRandomSessionID() string {
 	b := make([]byte, 16)
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
 
-func generateFakeCert() string {
-	return `-----BEGIN CERTIFICATE-----
-MIIQD...... (Mock Certificate Data) ......
-......
-......
------END CERTIFICATE-----`
+// isSHA1Signature reports whether alg hashes with SHA-1.
+func isSHA1Signature(alg x509.SignatureAlgorithm) bool {
+	switch alg {
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return true
+	}
+	return false
+}
+
+// checkCNInSANs enforces that a CSR's common name, when present, is also
+// one of its DNS SANs. It returns a description of the problem, or "".
+func checkCNInSANs(csr *x509.CertificateRequest) string {
+	cn := csr.Subject.CommonName
+	if cn == "" {
+		return ""
+	}
+	for _, name := range csr.DNSNames {
+		if strings.EqualFold(name, cn) {
+			return ""
+		}
+	}
+	return "Common name " + cn + " is not among the CSR's DNS SANs"
+}
+
+// normalizeSubject canonicalizes a requested subject the way a CA would
+// before issuing: values are trimmed and empty ones dropped, country
+// codes are upper-cased and a common name that is a domain is normalized
+// like a DNS SAN.
+func normalizeSubject(n pkix.Name) pkix.Name {
+	clean := func(vals []string, f func(string) string) []string {
+		var out []string
+		for _, v := range vals {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, f(v))
+			}
+		}
+		return out
+	}
+	keep := func(s string) string { return s }
+
+	out := pkix.Name{
+		Country:            clean(n.Country, strings.ToUpper),
+		Organization:       clean(n.Organization, keep),
+		OrganizationalUnit: clean(n.OrganizationalUnit, keep),
+		Locality:           clean(n.Locality, keep),
+		Province:           clean(n.Province, keep),
+		StreetAddress:      clean(n.StreetAddress, keep),
+		PostalCode:         clean(n.PostalCode, keep),
+		SerialNumber:       strings.TrimSpace(n.SerialNumber),
+		CommonName:         strings.TrimSpace(n.CommonName),
+	}
+	if cn := out.CommonName; strings.Contains(cn, ".") && !strings.ContainsAny(cn, " @") {
+		out.CommonName = normalizeDNSName(cn)
+	}
+	return out
+}
+
+// normalizeDNSName lower-cases a domain name and drops a trailing dot.
+func normalizeDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// normalizeDNSNames applies normalizeDNSName to each name.
+func normalizeDNSNames(names []string) []string {
+	var out []string
+	for _, name := range names {
+		out = append(out, normalizeDNSName(name))
+	}
+	return out
+}
+
+// csrSANs flattens the subject alternative names of the certificate
+// issued for csr under profile, which may be nil: those the CSR requests,
+// plus any the profile adds.
+func csrSANs(csr *x509.CertificateRequest, profile *CertTemplate) []string {
+	var sans []string
+	sans = append(sans, profile.dnsNames(normalizeDNSNames(csr.DNSNames), normalizeSubject(csr.Subject).CommonName)...)
+	for _, ip := range csr.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, csr.EmailAddresses...)
+	return sans
+}
+
+// formatOrderNumber expands an orderNumber template. Supported
+// placeholders are {id} (the sslId), {date} (YYYYMMDD of creation) and
+// {rand} (six random digits).
+func formatOrderNumber(tmpl string, id int, created time.Time) string {
+	var n [4]byte
+	rand.Read(n[:])
+	r := (uint32(n[0])<<24 | uint32(n[1])<<16 | uint32(n[2])<<8 | uint32(n[3])) % 1000000
+	return strings.NewReplacer(
+		"{id}", strconv.Itoa(id),
+		"{date}", created.Format("20060102"),
+		"{rand}", fmt.Sprintf("%06d", r),
+	).Replace(tmpl)
 }
 
 func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/ssl/v1/user/auth", handleAuth)
-	mux.HandleFunc("/api/ssl/v1/enroll", handleEnroll)
-	mux.HandleFunc("/api/ssl/v1/status/", handleStatus)   // Trailing slash for path params
-	mux.HandleFunc("/api/ssl/v1/collect/", handleCollect) // Trailing slash for path params
-	mux.HandleFunc("/api/ssl/v1/revoke", handleRevoke)
-
-	log.Println("Mock Setigo API Server listening on :3001")
-	if err := http.ListenAndServe(":3001", mux); err != nil {
+	opts := DefaultOptions()
+	flag.BoolVar(&opts.EnableAdmin, "enable-admin", false, "Enable the /api/ssl/v1/admin/ test-control endpoints")
+	flag.StringVar(&opts.AdminToken, "admin-token", "", "Require this value in the X-Admin-Token header of admin requests (empty allows any)")
+	flag.DurationVar(&opts.IssuanceDelay, "issuance-delay", opts.IssuanceDelay, "How long orders stay pending before issuance (0 issues before enroll responds); enroll's issuanceDelaySeconds overrides it")
+	flag.StringVar(&opts.ErrorFormat, "error-format", opts.ErrorFormat, "Error response format: plain (Sectigo-style JSON {\"code\",\"message\"}) or problem (RFC 7807 application/problem+json)")
+	flag.StringVar(&opts.LogFormat, "log-format", opts.LogFormat, "Format of the per-request log lines: text (key=value) or json")
+	logLines := flag.Int("log-buffer-lines", 1000, "Number of recent log lines kept for /api/ssl/v1/admin/logs")
+	disabled := flag.String("disable-endpoints", "", "Comma-separated endpoints to disable: auth,ca,enroll,status,collect,revoke,revoked,unhold,order,orders,changes,products,validation,renew,dcv,reissue,crl")
+	flag.IntVar(&opts.DisabledStatus, "disabled-status", opts.DisabledStatus, "HTTP status returned by disabled endpoints (404 or 503)")
+	flag.DurationVar(&opts.MaxRequestDuration, "max-request-duration", 0, "Answer 504 when an API request takes longer than this (0 disables)")
+	flag.IntVar(&opts.MaxValidityDays, "max-validity-days", 0, "Clamp requested terms to this many days, warning in the enroll response (0 disables)")
+	flag.IntVar(&opts.RenewalWindowDays, "renewal-window-days", opts.RenewalWindowDays, "Issued orders are renewable within this many days of expiry")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "Reject connections beyond this many concurrent ones per client IP (0 disables)")
+	flag.StringVar(&opts.Approval, "approval", opts.Approval, "Order approval: auto issues after the issuance delay, manual keeps orders pending until POST /api/ssl/v1/admin/approve")
+	flag.DurationVar(&opts.AuthDelay, "auth-delay", 0, "Delay before answering the auth endpoint, to simulate slow login")
+	flag.StringVar(&opts.OrderNumberFormat, "order-number-format", opts.OrderNumberFormat, "Template for generated orderNumbers; placeholders {id}, {date}, {rand} (e.g. CO-{id} or {date}-{id})")
+	flag.IntVar(&opts.IssueAfterPolls, "issue-after-polls", 0, "Keep orders pending until their status has been polled this many times, then issue (0 uses the issuance delay)")
+	latency := flag.String("latency-schedule", "", "Extra latency during windows relative to server start, e.g. 30s-60s=500ms,2m-3m=2s")
+	moved := flag.String("moved-paths", "", "Relocate endpoints: comma-separated /old=/new pairs; old paths redirect to new ones")
+	flag.IntVar(&opts.MovedStatus, "moved-status", opts.MovedStatus, "Redirect status for -moved-paths (307 or 308)")
+	flag.DurationVar(&opts.CollectLag, "collect-lag", 0, "Keep collect answering not ready for this long after an order is issued")
+	flag.BoolVar(&opts.ReissueRevokes, "reissue-revokes-superseded", false, "On reissue, list the replaced certificate's serial in /api/ssl/v1/revoked with reason superseded")
+	flag.DurationVar(&opts.OrderTTL, "order-ttl", 0, "Remove issued/revoked orders this long after they completed; pending orders are never removed (0 disables)")
+	progression := flag.String("status-progression", "", "Intermediate statuses pending orders report, as comma-separated name=dwell pairs, e.g. applied=2s,requested=2s,approved=1s; sets the issuance delay to their total unless -issuance-delay is given")
+	steps := flag.String("validation-steps", defaultValidationSteps, "Comma-separated validation steps for /api/ssl/v1/validation/{id}; name=status pins a step's status")
+	flag.BoolVar(&opts.DebugAuth, "debug-auth", false, "INSECURE, development only: log the credentials received by the auth endpoint")
+	flag.DurationVar(&opts.RevokeLag, "revoke-lag", 0, "Answer revoke with success immediately but keep the old status for this long")
+	flag.DurationVar(&opts.RevocationLag, "revocation-lag", 0, "Keep a revoked certificate off the CRL for this long after its status flips, simulating slow revocation propagation")
+	flag.BoolVar(&opts.RejectSHA1, "reject-sha1", false, "Reject CSRs whose signature uses SHA-1")
+	flag.BoolVar(&opts.RequireCNInSANs, "require-cn-in-sans", false, "Reject CSRs whose common name is not repeated among their DNS SANs")
+	flag.DurationVar(&opts.CRLValidity, "crl-validity", opts.CRLValidity, "Time from thisUpdate to nextUpdate of the CRL at /api/ssl/v1/crl; it is re-signed with the next CRL number when revocations change or nextUpdate passes")
+	flag.DurationVar(&opts.CacheMaxAge, "cache-max-age", 0, "Send Cache-Control max-age on status and CA responses (0 and no -cache-stale-while-revalidate sends none)")
+	flag.DurationVar(&opts.CacheStale, "cache-stale-while-revalidate", 0, "Add stale-while-revalidate to the Cache-Control of status and CA responses")
+	flag.StringVar(&opts.BadCA, "bad-ca", "", "Sign with a CA clients must refuse, for negative tests: expired (CA certificates expired a year ago) or weak (1024-bit RSA CA keys)")
+	flag.StringVar(&opts.SKIMethod, "ski-method", opts.SKIMethod, "SubjectKeyIdentifier derivation for issued and CA certificates: sha1 (RFC 5280) or sha256 (RFC 7093, truncated)")
+	// :3001 rather than :8080: the mock has always listened there, and
+	// clients and docs (client.New, openapi.json servers) point at it.
+	addr := flag.String("addr", ":3001", "Address to listen on; the default keeps the mock's historical port 3001, not :8080, so existing clients still find it. Without -addr, $PORT, if set, replaces the default port. :0 picks a free port, which is logged")
+	corsOrigins := flag.String("cors-origin", "", "Comma-separated origins allowed to call the API from a browser, or * for any (empty disables CORS)")
+	flag.DurationVar(&opts.DayLength, "day-length", opts.DayLength, "How long a day of certificate validity lasts, e.g. 1s to let a 365-day certificate expire after about 6 minutes; also scales -renewal-window-days")
+	chains := flag.String("chains", "", "Comma-separated intermediate variants, e.g. modern,legacy; leaves are signed by a shared intermediate cross-signed by one root per variant, the first being the default")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file to serve HTTPS with (requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	tlsSelfSigned := flag.Bool("tls-self-signed", false, "Serve HTTPS with an ephemeral self-signed certificate for localhost when -tls-cert is not given")
+	shutdownGrace := flag.Duration("shutdown-grace", 10*time.Second, "On SIGINT/SIGTERM, how long to let in-flight requests finish before closing them")
+	flag.DurationVar(&opts.IdempotencyTTL, "idempotency-ttl", opts.IdempotencyTTL, "How long an enroll response is replayed to requests reusing its Idempotency-Key")
+	flag.DurationVar(&opts.SessionTTL, "session-ttl", opts.SessionTTL, "Lifetime of tokens issued by the auth endpoint (0 never expires)")
+	degraded := flag.String("degraded-components", "", "Comma-separated components reported degraded by /api/ssl/v1/status/service: signer,store,ocsp")
+	flag.BoolVar(&opts.RandomIDs, "random-ids", false, "Assign random, unused order IDs between 10000000 and 99999999 instead of sequential ones")
+	flag.StringVar(&opts.StoreFile, "store-file", "", "JSON file that orders are loaded from at startup and saved to on every change (empty keeps them in memory only)")
+	flag.BoolVar(&opts.StoreGzip, "store-gzip", false, "Gzip -store-file when saving it; gzipped files are detected and decompressed on load either way")
+	flag.BoolVar(&opts.EnrollErrorsOK, "enroll-errors-200", false, "Answer enroll validation failures with HTTP 200 and {\"sslId\":0,\"code\":...} like the real API sometimes does")
+	flag.BoolVar(&opts.DCV, "dcv", false, "Return domain control validation challenges on enroll and keep orders pending validation until POST /api/ssl/v1/dcv/validate")
+	flag.IntVar(&opts.RateLimit, "rate-limit", 0, "Requests per minute allowed per session token, or per IP for unauthenticated requests, before answering 429 (0 disables)")
+	flag.BoolVar(&opts.AllowHeaderFaults, "allow-header-faults", false, "Fail any API request that carries an X-Mock-Fail: <status> header with that status")
+	flag.Float64Var(&opts.Chaos.FailRate, "fail-rate", 0, "Percentage (0-100) of enroll/status/collect calls that fail; see also /api/ssl/v1/admin/chaos")
+	flag.StringVar(&opts.Chaos.Mode, "fail-mode", opts.Chaos.Mode, "How -fail-rate failures look: error (HTTP 500), malformed (200 with truncated JSON) or mixed")
+	chaosSeed := flag.Uint64("chaos-seed", 0, "Seed for chaos failures, for reproducible runs (0 picks a random seed)")
+	statuses := flag.String("error-status", "", "Override the HTTP status per Sectigo error code: comma-separated code=status pairs, e.g. -40=200,-103=422")
+	catalogFile := flag.String("catalog", "", "JSON file of products (code, name, terms, maxValidityDays) replacing the built-in catalog")
+	seedFile := flag.String("seed", "", "JSON file of orders (id, status, commonName or csr, ...) to create at startup with the given IDs and statuses")
+	rulesFile := flag.String("scenario-rules", "", "JSON file of rules mapping CSR common-name patterns to outcomes (issue/fail/revoke)")
+	flag.Parse()
+
+	if opts.DebugAuth {
+		log.Println("WARNING: -debug-auth is enabled. Received passwords will be written to the log in plain text.")
+		log.Println("WARNING: never use -debug-auth outside local development.")
+	}
+
+	var err error
+	if opts.LatencySchedule, err = parseLatencySchedule(*latency); err != nil {
+		log.Fatalf("invalid -latency-schedule: %v", err)
+	}
+	if *catalogFile != "" {
+		if opts.Catalog, err = loadCatalog(*catalogFile); err != nil {
+			log.Fatalf("invalid -catalog: %v", err)
+		}
+		log.Printf("Loaded %d products from %s", len(opts.Catalog), *catalogFile)
+	}
+	if *rulesFile != "" {
+		if opts.ScenarioRules, err = loadScenarioRules(*rulesFile); err != nil {
+			log.Fatalf("invalid -scenario-rules: %v", err)
+		}
+		log.Printf("Loaded %d scenario rules from %s", len(opts.ScenarioRules), *rulesFile)
+	}
+	if *seedFile != "" {
+		if opts.Seed, err = loadSeedOrders(*seedFile); err != nil {
+			log.Fatalf("invalid -seed: %v", err)
+		}
+	}
+	if *chaosSeed != 0 {
+		opts.Chaos.Seed = chaosSeed
+	}
+	if opts.ErrorStatuses, err = parseErrorStatuses(*statuses); err != nil {
+		log.Fatalf("invalid -error-status: %v", err)
+	}
+	if opts.ValidationSteps, err = parseValidationSteps(*steps); err != nil {
+		log.Fatalf("invalid -validation-steps: %v", err)
+	}
+	if opts.StatusProgression, err = parseStatusProgression(*progression); err != nil {
+		log.Fatalf("invalid -status-progression: %v", err)
+	}
+	if len(opts.StatusProgression) > 0 && !isFlagSet("issuance-delay") {
+		opts.IssuanceDelay = progressionDuration(opts.StatusProgression)
+	}
+	if opts.MovedPaths, err = parseMovedPaths(*moved); err != nil {
+		log.Fatalf("invalid -moved-paths: %v", err)
+	}
+	opts.DisabledEndpoints = splitSet(*disabled)
+	opts.DegradedComponents = splitSet(*degraded)
+	for _, origin := range strings.Split(*corsOrigins, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			opts.CORSOrigins = append(opts.CORSOrigins, origin)
+		}
+	}
+	for _, name := range strings.Split(*chains, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Chains = append(opts.Chains, name)
+		}
+	}
+
+	if opts.EnableAdmin {
+		logBuffer = newLogRing(*logLines)
+		log.SetOutput(io.MultiWriter(os.Stderr, logBuffer))
+	}
+
+	srv, err := NewServer(opts)
+	if err != nil {
 		log.Fatal(err)
 	}
+	if srv.Chaos.FailRate > 0 {
+		log.Printf("Chaos: %.1f%% of calls fail (%s), seed %d", srv.Chaos.FailRate, srv.Chaos.Mode, *srv.Chaos.Seed)
+	}
+	for name := range srv.DisabledEndpoints {
+		log.Printf("Endpoint %s disabled (returns %d)", name, srv.DisabledStatus)
+	}
+	if len(srv.Chains) > 0 {
+		log.Printf("Issuing via intermediate variants %s (default %s)", strings.Join(srv.Chains, ", "), srv.Chains[0])
+	}
+	for name := range srv.DegradedComponents {
+		log.Printf("Component %s reports degraded", name)
+	}
+	if srv.RateLimit > 0 {
+		log.Printf("Rate limiting to %d requests per minute per client", srv.RateLimit)
+	}
+	if srv.DayLength != 24*time.Hour {
+		log.Printf("Certificate days last %s", srv.DayLength)
+	}
+	if srv.OrderTTL > 0 {
+		log.Printf("Sweeping completed orders after %s", srv.OrderTTL)
+	}
+	if len(srv.StatusProgression) > 0 {
+		names := make([]string, len(srv.StatusProgression))
+		for i, st := range srv.StatusProgression {
+			names[i] = st.name
+		}
+		log.Printf("Pending orders progress through %s; issuance after %s", strings.Join(names, ", "), srv.IssuanceDelay)
+	}
+	if len(srv.CORSOrigins) > 0 {
+		log.Printf("CORS enabled for %s", strings.Join(srv.CORSOrigins, ", "))
+	}
+	for from, to := range srv.MovedPaths {
+		log.Printf("Endpoint %s moved to %s (%d)", from, to, srv.MovedStatus)
+	}
+	if srv.EnableAdmin {
+		log.Println("Admin endpoints enabled under /api/ssl/v1/admin/")
+		if srv.AdminToken != "" {
+			log.Println("Admin endpoints require X-Admin-Token")
+		}
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+	var tlsConfig *tls.Config
+	switch {
+	case *tlsCert != "":
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("loading -tls-cert: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case *tlsSelfSigned:
+		cert, fingerprint, pin, err := selfSignedTLSCert()
+		if err != nil {
+			log.Fatalf("creating self-signed certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		log.Printf("Self-signed TLS certificate SHA-256 fingerprint %s", fingerprint)
+		log.Printf("Self-signed TLS certificate SPKI pin sha256/%s", pin)
+	}
+
+	if port := os.Getenv("PORT"); port != "" && !isFlagSet("addr") {
+		*addr = ":" + port
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *maxConnsPerIP > 0 {
+		ln = newPerIPLimitListener(ln, *maxConnsPerIP)
+		log.Printf("Limiting clients to %d concurrent connections per IP", *maxConnsPerIP)
+	}
+
+	httpSrv := &http.Server{Handler: srv.Handler(), TLSConfig: tlsConfig}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 1)
+	scheme := "HTTP"
+	if tlsConfig != nil {
+		// Certificates come from TLSConfig, so ServeTLS gets no files.
+		go func() { serveErr <- httpSrv.ServeTLS(ln, "", "") }()
+		scheme = "HTTPS"
+	} else {
+		go func() { serveErr <- httpSrv.Serve(ln) }()
+	}
+
+	// The resolved address, so harnesses using :0 can find the port.
+	log.Printf("Mock Setigo API Server listening on %s (%s)", ln.Addr(), scheme)
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal kills the process immediately
+
+	log.Printf("Shutting down, draining requests for up to %s", *shutdownGrace)
+	srv.Drain()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownGrace)
+	defer cancel()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown: %v", err)
+	}
+	if n := srv.Close(); n > 0 {
+		log.Printf("Cancelled %d scheduled order changes", n)
+	}
+	log.Println("Server stopped")
+}
+
+// splitSet returns the non-empty entries of a comma-separated flag value.
+func splitSet(spec string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
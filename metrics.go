@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Prometheus Metrics ---
+//
+// GET /metrics serves request counts and latencies per endpoint and the
+// number of orders per status in the Prometheus text exposition format.
+// Like the health probes it is unauthenticated and never disabled.
+
+// orderStatuses are always reported by the orders gauge, even when zero.
+var orderStatuses = []string{"pending", "issued", "held", "revoked", "declined", "expired"}
+
+// latencyBuckets are the upper bounds, in seconds, of the request
+// duration histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	endpoint string
+	status   int
+}
+
+// histogram counts observations per bucket; counts[i] holds those not
+// above latencyBuckets[i] and not in an earlier bucket, the last entry
+// those above every bound.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	i, _ := slices.BinarySearch(latencyBuckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// metrics accumulates what withMetrics observes.
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	latency  map[string]*histogram // By endpoint
+}
+
+func (m *metrics) record(endpoint string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{endpoint, status}]++
+	h, ok := m.latency[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		m.latency[endpoint] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// withMetrics counts h's responses by status and times them under the
+// endpoint name. It sits outside everything else in endpoint, so
+// disabled endpoints, chaos faults and timeouts are counted too.
+func (s *Server) withMetrics(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		h(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		s.metrics.record(name, sr.status, time.Since(start))
+	}
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	byStatus := make(map[string]int, len(orderStatuses))
+	for _, status := range orderStatuses {
+		byStatus[status] = 0
+	}
+	s.mu.RLock()
+	for _, o := range s.orders {
+		byStatus[o.Status]++
+	}
+	s.mu.RUnlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP mock_setigo_orders Orders currently held by the mock, by status.\n")
+	b.WriteString("# TYPE mock_setigo_orders gauge\n")
+	for _, status := range slices.Sorted(maps.Keys(byStatus)) {
+		fmt.Fprintf(&b, "mock_setigo_orders{status=%q} %d\n", status, byStatus[status])
+	}
+
+	s.metrics.mu.Lock()
+	keys := make([]requestKey, 0, len(s.metrics.requests))
+	for k := range s.metrics.requests {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if c := strings.Compare(a.endpoint, b.endpoint); c != 0 {
+			return c
+		}
+		return a.status - b.status
+	})
+	b.WriteString("# HELP mock_setigo_requests_total API requests answered, by endpoint and HTTP status.\n")
+	b.WriteString("# TYPE mock_setigo_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "mock_setigo_requests_total{endpoint=%q,code=\"%d\"} %d\n", k.endpoint, k.status, s.metrics.requests[k])
+	}
+
+	b.WriteString("# HELP mock_setigo_request_duration_seconds Time taken to answer API requests, by endpoint.\n")
+	b.WriteString("# TYPE mock_setigo_request_duration_seconds histogram\n")
+	for _, endpoint := range slices.Sorted(maps.Keys(s.metrics.latency)) {
+		h := s.metrics.latency[endpoint]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "mock_setigo_request_duration_seconds_bucket{endpoint=%q,le=\"%g\"} %d\n", endpoint, le, cumulative)
+		}
+		fmt.Fprintf(&b, "mock_setigo_request_duration_seconds_bucket{endpoint=%q,le=\"+Inf\"} %d\n", endpoint, h.count)
+		fmt.Fprintf(&b, "mock_setigo_request_duration_seconds_sum{endpoint=%q} %g\n", endpoint, h.sum)
+		fmt.Fprintf(&b, "mock_setigo_request_duration_seconds_count{endpoint=%q} %d\n", endpoint, h.count)
+	}
+	s.metrics.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
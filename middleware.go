@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Middleware ---
+
+// withTimeout aborts h once it has run for longer than d and answers 504,
+// simulating a gateway in front of the CA timing out. It works like
+// http.TimeoutHandler but with a gateway-timeout status and our error
+// format. The handler's context is cancelled on timeout and anything it
+// writes afterwards is discarded.
+func (s *Server) withTimeout(h http.HandlerFunc, d time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicChan := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicChan <- p
+				}
+			}()
+			h(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicChan:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			dst := w.Header()
+			for k, v := range tw.header {
+				dst[k] = v
+			}
+			if tw.code == 0 {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			s.writeError(w, http.StatusGatewayTimeout, errCodeUnknown, "Request exceeded maximum duration")
+		}
+	}
+}
+
+// timeoutWriter buffers a handler's response until withTimeout decides
+// whether it finished in time.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// latencyWindow adds delay to requests arriving between start and end,
+// both measured from server start.
+type latencyWindow struct {
+	start, end time.Duration
+	delay      time.Duration
+}
+
+func (lw latencyWindow) String() string {
+	return fmt.Sprintf("%s-%s=%s", lw.start, lw.end, lw.delay)
+}
+
+// parseLatencySchedule parses "start-end=delay" entries separated by
+// commas, e.g. "30s-60s=500ms,2m-3m=2s".
+func parseLatencySchedule(spec string) ([]latencyWindow, error) {
+	var windows []latencyWindow
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		span, delayStr, ok := strings.Cut(entry, "=")
+		startStr, endStr, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("latency window %q: want start-end=delay", entry)
+		}
+		var lw latencyWindow
+		var err error
+		if lw.start, err = time.ParseDuration(startStr); err != nil {
+			return nil, fmt.Errorf("latency window %q: %w", entry, err)
+		}
+		if lw.end, err = time.ParseDuration(endStr); err != nil {
+			return nil, fmt.Errorf("latency window %q: %w", entry, err)
+		}
+		if lw.delay, err = time.ParseDuration(delayStr); err != nil {
+			return nil, fmt.Errorf("latency window %q: %w", entry, err)
+		}
+		if lw.end <= lw.start {
+			return nil, fmt.Errorf("latency window %q: end must be after start", entry)
+		}
+		windows = append(windows, lw)
+	}
+	return windows, nil
+}
+
+// scheduledLatency returns the extra delay for a request arriving at
+// elapsed time since start. Overlapping windows add up.
+func scheduledLatency(windows []latencyWindow, elapsed time.Duration) time.Duration {
+	var d time.Duration
+	for _, lw := range windows {
+		if elapsed >= lw.start && elapsed < lw.end {
+			d += lw.delay
+		}
+	}
+	return d
+}
+
+// withScheduledLatency delays requests that fall inside a latency window.
+func (s *Server) withScheduledLatency(h http.HandlerFunc, windows []latencyWindow) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d := scheduledLatency(windows, time.Since(s.startedAt)); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// withMovedPaths simulates endpoints that have been relocated: requests to
+// an old path get a redirect to its new path, and the new path is served
+// by the handler that used to live at the old one. 307 and 308 both
+// require clients to repeat the method and body.
+func withMovedPaths(h http.Handler, moved map[string]string, status int) http.Handler {
+	servedAt := make(map[string]string, len(moved))
+	for from, to := range moved {
+		servedAt[to] = from
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to, ok := moved[r.URL.Path]; ok {
+			target := to
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, status)
+			return
+		}
+		if from, ok := servedAt[r.URL.Path]; ok {
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = from
+			r2.URL.RawPath = ""
+			h.ServeHTTP(w, r2)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// CORS headers for -cors-origin. Browsers only let scripts send the
+// custom headers of the API, and read the informative response headers,
+// once the server has listed them.
+const (
+	corsAllowMethods  = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders  = "Content-Type, Accept-Language, token, " + idempotencyKeyHeader + ", " + requestIDHeader + ", X-Mock-Fail, Authorization, " + adminTokenHeader
+	corsExposeHeaders = "Retry-After, Content-Disposition, " + idempotentReplayHeader + ", " + requestIDHeader
+	corsMaxAge        = "600"
+)
+
+// withCORS lets browsers on origins call the API: requests whose Origin
+// is allowed get it echoed back, and their preflight OPTIONS requests are
+// answered here with 204 instead of reaching the handlers. An origin of
+// "*" allows any. Requests from other origins pass through unchanged, and
+// without CORS headers the browser blocks them.
+func withCORS(h http.Handler, origins []string) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !(allowed["*"] || allowed[origin]) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parseMovedPaths parses comma-separated "old=new" path pairs.
+func parseMovedPaths(spec string) (map[string]string, error) {
+	moved := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(from, "/") || !strings.HasPrefix(to, "/") || from == to {
+			return nil, fmt.Errorf("moved path %q: want /old=/new", entry)
+		}
+		moved[from] = to
+	}
+	return moved, nil
+}
+
+// Log formats for -log-format.
+const (
+	logFormatText = "text" // key=value pairs
+	logFormatJSON = "json" // One JSON object per line
+)
+
+// requestIDHeader correlates a request with its log line. A client-sent
+// ID is echoed back; otherwise one is generated.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds client-sent request IDs; longer ones are
+// replaced rather than logged.
+const maxRequestIDLen = 128
+
+// statusRecorder remembers the status a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+// Flush keeps streaming handlers such as /admin/logs working.
+func (sr *statusRecorder) Flush() {
+	http.NewResponseController(sr.ResponseWriter).Flush()
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter { return sr.ResponseWriter }
+
+// withRequestLog assigns each request an X-Request-ID, echoes it in the
+// response and logs one line per request with its method, path, status
+// and latency.
+func withRequestLog(h http.Handler, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLen {
+			id = generateRandomSessionID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		logger.Info("request",
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sr.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+	})
+}
+
+// newRequestLogger returns the logger for withRequestLog, writing to the
+// standard logger's output in format.
+func newRequestLogger(format string) *slog.Logger {
+	if format == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(log.Writer(), nil))
+	}
+	return slog.New(slog.NewTextHandler(log.Writer(), nil))
+}
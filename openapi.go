@@ -0,0 +1,48 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// --- OpenAPI Document ---
+
+// openAPISpec describes every route registered by Handler, including the
+// admin ones. Keep it in sync when adding endpoints or status codes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves openAPISpec, without the admin paths unless
+// EnableAdmin is set. Like the health probes it is unauthenticated.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	body := openAPISpec
+	if !s.EnableAdmin {
+		var doc map[string]any
+		if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+			s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to decode OpenAPI document")
+			return
+		}
+		paths, _ := doc["paths"].(map[string]any)
+		for p := range paths {
+			if strings.HasPrefix(p, "/api/ssl/v1/admin/") {
+				delete(paths, p)
+			}
+		}
+		var err error
+		if body, err = json.MarshalIndent(doc, "", "  "); err != nil {
+			s.writeError(w, http.StatusInternalServerError, errCodeUnknown, "Failed to encode OpenAPI document")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
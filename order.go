@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// --- Order Sub-Resources ---
+
+type RenewableResponse struct {
+	SslId               int       `json:"sslId"`
+	Status              string    `json:"status"`
+	Renewable           bool      `json:"renewable"`
+	InRenewalWindow     bool      `json:"inRenewalWindow"`
+	ExpiresAt           time.Time `json:"expiresAt,omitzero"`
+	EarliestRenewalDate time.Time `json:"earliestRenewalDate,omitzero"`
+	Reason              string    `json:"reason,omitempty"`
+}
+
+type VerifyKeyRequest struct {
+	PrivateKey string `json:"privateKey"`
+}
+
+type VerifyKeyResponse struct {
+	SslId int  `json:"sslId"`
+	Match bool `json:"match"`
+}
+
+type SPKIPinResponse struct {
+	SslId     int    `json:"sslId"`
+	Algorithm string `json:"algorithm"`
+	Pin       string `json:"pin"`
+}
+
+const defaultOrdersLimit = 50
+
+// handleOrders lists orders by ascending ID, optionally only those with
+// ?status=, windowed by ?limit= and ?offset=. X-Total-Count carries the
+// number of matching orders before the window is applied.
+func (s *Server) handleOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset := defaultOrdersLimit, 0
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &limit}, {"offset", &offset}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid "+p.name+" value")
+				return
+			}
+			*p.dst = n
+		}
+	}
+	status := q.Get("status")
+
+	s.mu.RLock()
+	matched := make([]Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		if status == "" || o.Status == status {
+			matched = append(matched, *o)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+	total := len(matched)
+	start := min(offset, total)
+	page := matched[start : start+min(limit, total-start)]
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleChanges streams, as newline-delimited JSON, the orders whose
+// updatedAt is after ?since= (RFC 3339), oldest change first. Without
+// since every order is sent. Orders removed by the sweeper are not
+// reported.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid since value")
+			return
+		}
+		since = t
+	}
+
+	s.mu.RLock()
+	changed := make([]Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		if o.UpdatedAt.After(since) {
+			changed = append(changed, *o)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(changed, func(i, j int) bool {
+		if !changed[i].UpdatedAt.Equal(changed[j].UpdatedAt) {
+			return changed[i].UpdatedAt.Before(changed[j].UpdatedAt)
+		}
+		return changed[i].ID < changed[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for i := range changed {
+		if err := enc.Encode(&changed[i]); err != nil {
+			return
+		}
+	}
+}
+
+// handleOrder dispatches /api/ssl/v1/order/{id}/{action}.
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+
+	action := r.PathValue("action")
+	switch action {
+	case "renewable":
+		s.handleOrderRenewable(w, r, orderID)
+	case "verify-key":
+		s.handleOrderVerifyKey(w, r, orderID)
+	case "spki-pin":
+		s.handleOrderSPKIPin(w, r, orderID)
+	default:
+		s.writeError(w, http.StatusNotFound, errCodeInvalidRequest, "Unknown order action: "+action)
+	}
+}
+
+// handleOrderRenewable reports whether an order may be renewed: it must be
+// issued and within -renewal-window-days of its expiry.
+func (s *Server) handleOrderRenewable(w http.ResponseWriter, r *http.Request, orderID int) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	s.mu.RLock()
+	order, ok := s.orders[orderID]
+	var resp RenewableResponse
+	if ok {
+		resp = s.renewability(order, time.Now())
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// renewability evaluates the renewal rules for o at now. mu must be held.
+func (s *Server) renewability(o *Order, now time.Time) RenewableResponse {
+	resp := RenewableResponse{SslId: o.ID, Status: o.Status}
+	if o.Status != "issued" && o.Status != "expired" {
+		resp.Reason = "Only issued certificates can be renewed"
+		return resp
+	}
+
+	resp.ExpiresAt = o.ExpiresAt
+	resp.EarliestRenewalDate = resp.ExpiresAt.Add(-s.validity(s.RenewalWindowDays))
+	if resp.EarliestRenewalDate.Before(o.IssuedAt) {
+		resp.EarliestRenewalDate = o.IssuedAt
+	}
+	resp.InRenewalWindow = !now.Before(resp.EarliestRenewalDate) && now.Before(resp.ExpiresAt)
+	resp.Renewable = resp.InRenewalWindow
+	if !resp.Renewable {
+		if now.Before(resp.EarliestRenewalDate) {
+			resp.Reason = "Not yet within the renewal window"
+		} else {
+			resp.Reason = "Certificate has expired"
+		}
+	}
+	return resp
+}
+
+// handleOrderVerifyKey reports whether a PEM private key belongs to the
+// order's certificate. The comparison is against the public key in the
+// order's CSR, which is the key the certificate is issued for.
+func (s *Server) handleOrderVerifyKey(w http.ResponseWriter, r *http.Request, orderID int) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	var req VerifyKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	key, err := parsePrivateKeyPEM(req.PrivateKey)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid private key: "+err.Error())
+		return
+	}
+
+	s.mu.RLock()
+	order, ok := s.orders[orderID]
+	var status, csrPEM string
+	if ok {
+		status, csrPEM = order.Status, order.CSR
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if status != "issued" {
+		s.writeError(w, http.StatusBadRequest, errCodeOrderState, "Certificate not ready (status: "+status+")")
+		return
+	}
+
+	csr, err := parseCSRPEM(csrPEM)
+	if err != nil {
+		s.writeError(w, http.StatusConflict, errCodeInvalidCSR, "Order has no parseable CSR to compare against")
+		return
+	}
+
+	pub, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	match := ok && pub.Equal(key.Public())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VerifyKeyResponse{SslId: orderID, Match: match})
+}
+
+// handleOrderSPKIPin returns the base64 SHA-256 of the certificate's
+// SubjectPublicKeyInfo, the pin-sha256 format used by HPKP. The SPKI is
+// taken from the order's CSR, which is what the certificate carries.
+func (s *Server) handleOrderSPKIPin(w http.ResponseWriter, r *http.Request, orderID int) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	s.mu.RLock()
+	order, ok := s.orders[orderID]
+	var status, csrPEM string
+	if ok {
+		status, csrPEM = order.Status, order.CSR
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if status != "issued" {
+		s.writeError(w, http.StatusBadRequest, errCodeOrderState, "Certificate not ready (status: "+status+")")
+		return
+	}
+
+	csr, err := parseCSRPEM(csrPEM)
+	if err != nil {
+		s.writeError(w, http.StatusConflict, errCodeInvalidCSR, "Order has no parseable CSR to derive the public key from")
+		return
+	}
+	sum := sha256.Sum256(csr.RawSubjectPublicKeyInfo)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SPKIPinResponse{
+		SslId:     orderID,
+		Algorithm: "sha256",
+		Pin:       base64.StdEncoding.EncodeToString(sum[:]),
+	})
+}
+
+// parseCSRPEM decodes and parses a PEM-encoded PKCS#10 request.
+func parseCSRPEM(data string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return x509.ParseCertificateRequest(block.Bytes)
+}
+
+// parsePrivateKeyPEM accepts PKCS#8, PKCS#1 RSA and SEC 1 EC private keys.
+func parsePrivateKeyPEM(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported key type")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key encoding")
+}
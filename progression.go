@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// --- Status Progression ---
+
+// statusStage is one intermediate status a pending order reports, for
+// dwell before moving on to the next.
+type statusStage struct {
+	name  string
+	dwell time.Duration
+}
+
+func (st statusStage) String() string {
+	return st.name + "=" + st.dwell.String()
+}
+
+// parseStatusProgression parses "name=dwell,..." as used by
+// -status-progression, e.g. "applied=2s,requested=2s,approved=1s".
+func parseStatusProgression(spec string) ([]statusStage, error) {
+	var stages []statusStage
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, dwellStr, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("stage %q: want name=dwell", entry)
+		}
+		switch name {
+		case "issued", "held", "revoked", "declined", "expired":
+			return nil, fmt.Errorf("stage %q: %s is not an intermediate status", entry, name)
+		}
+		dwell, err := time.ParseDuration(dwellStr)
+		if err != nil {
+			return nil, fmt.Errorf("stage %q: %w", entry, err)
+		}
+		if dwell < 0 {
+			return nil, fmt.Errorf("stage %q: dwell must not be negative", entry)
+		}
+		stages = append(stages, statusStage{name: name, dwell: dwell})
+	}
+	return stages, nil
+}
+
+// progressionDuration is the total dwell of stages.
+func progressionDuration(stages []statusStage) time.Duration {
+	var d time.Duration
+	for _, st := range stages {
+		d += st.dwell
+	}
+	return d
+}
+
+// reportedStatus is the status handleStatus reports for o. A pending
+// order waiting on DCV is pending validation; otherwise it walks through
+// StatusProgression from enroll or its latest reissue and stays in the last stage until
+// it is issued. A certificate past its expiry is expired even before the
+// expirer moves it. With no progression, or once past pending, it is
+// o.Status.
+func (s *Server) reportedStatus(o *Order, now time.Time) string {
+	if certExpired(o, now) {
+		return "expired"
+	}
+	if o.Status == "pending" && o.DCVPending {
+		return statusPendingValidation
+	}
+	if o.Status != "pending" || len(s.StatusProgression) == 0 {
+		return o.Status
+	}
+	elapsed := now.Sub(o.pendingSince())
+	for _, st := range s.StatusProgression {
+		if elapsed < st.dwell {
+			return st.name
+		}
+		elapsed -= st.dwell
+	}
+	return s.StatusProgression[len(s.StatusProgression)-1].name
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- Rate Limiting ---
+
+// rateLimiter is a token bucket per client: each holds up to perMinute
+// requests and refills at perMinute per minute, so a client may burst
+// a minute's worth and is then throttled to the steady rate.
+type rateLimiter struct {
+	perMinute int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{perMinute: perMinute, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from client's bucket. If it is empty it returns
+// false and how long until the next token.
+func (rl *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	capacity := float64(rl.perMinute)
+	rate := capacity / time.Minute.Seconds() // Tokens per second
+	if now.Sub(rl.lastPrune) > time.Minute {
+		// Forget clients whose bucket has refilled: they start full anyway.
+		for k, b := range rl.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= capacity {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastPrune = now
+	}
+
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimitClient identifies who a request counts against: its session
+// token if that is valid, otherwise its remote IP.
+func (s *Server) rateLimitClient(r *http.Request) string {
+	token := sessionToken(r)
+	s.mu.RLock()
+	sess, ok := s.sessions[token]
+	valid := ok && sess.validAt(time.Now())
+	s.mu.RUnlock()
+	if valid {
+		return "token:" + token
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// checkRateLimit answers 429 with Retry-After and returns false when the
+// request's client has exceeded -rate-limit.
+func (s *Server) checkRateLimit(w http.ResponseWriter, r *http.Request) bool {
+	if s.limiter == nil {
+		return true
+	}
+	ok, wait := s.limiter.allow(s.rateLimitClient(r), time.Now())
+	if ok {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	s.writeError(w, http.StatusTooManyRequests, errCodeRateLimited, "Rate limit exceeded")
+	return false
+}
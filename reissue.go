@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// --- Reissue ---
+
+// ReissueRequest replaces the certificate of an issued order with one for
+// a new CSR, keeping the sslId.
+type ReissueRequest struct {
+	SslId flexID `json:"sslId"`
+	Csr   string `json:"csr"`
+}
+
+// RevokedCert is a certificate replaced by reissue and revoked as
+// superseded, under -reissue-revokes-superseded. The order itself stays
+// issued with the new certificate.
+type RevokedCert struct {
+	Serial    string    `json:"serial"`
+	CN        string    `json:"cn"`
+	RevokedAt time.Time `json:"revokedAt"`
+}
+
+type ReissueResponse struct {
+	SslId        int    `json:"sslId"`
+	Status       string `json:"status"`
+	ReissueCount int    `json:"reissueCount"`
+	Message      string `json:"message"`
+}
+
+// handleReissue moves an issued order back to pending with the new CSR and
+// re-signs it on the order's own schedule: after the delay it was
+// enrolled with, or on the -issue-after-polls poll. The replaced
+// certificate stays collectable with ?version=, and with
+// -reissue-revokes-superseded is revoked as superseded. Manual approval
+// and DCV were done for the original issuance and are not repeated.
+func (s *Server) handleReissue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	var req ReissueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	orderID, err := strconv.Atoi(string(req.SslId))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+	csr, ok := s.checkCSR(w, req.Csr)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	var resp ReissueResponse
+	var status string
+	reissued := false
+	if ok {
+		status = order.Status
+		if status == "issued" && !order.RevokePending {
+			now := time.Now()
+			if serial, ok := certSerial(order.Certificate); ok && s.ReissueRevokes {
+				order.SupersededRevoked = append(order.SupersededRevoked, RevokedCert{Serial: serial, CN: order.CommonName, RevokedAt: now})
+			}
+			order.PreviousCertificates = append(order.PreviousCertificates, order.Certificate)
+			order.Certificate = ""
+			order.CSR = req.Csr
+			order.CommonName = normalizeSubject(csr.Subject).CommonName
+			order.SANs = csrSANs(csr, s.certTemplate(order.ProductCode))
+			order.Status = "pending"
+			order.IssuedAt = time.Time{}
+			order.ExpiresAt = time.Time{}
+			order.StatusPolls = 0
+			order.PendingSince = now
+			order.ReissueCount++
+			order.UpdatedAt = now
+			reissued = true
+			s.startIssuanceLocked(order)
+			s.saveStoreLocked()
+		}
+		resp = ReissueResponse{SslId: orderID, Status: order.Status, ReissueCount: order.ReissueCount, Message: "Reissue requested"}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+	if !reissued {
+		s.writeError(w, http.StatusConflict, errCodeOrderState, "Only issued certificates can be reissued (status: "+status+")")
+		return
+	}
+	log.Printf("[Reissue] Order %d reissue #%d requested", orderID, resp.ReissueCount)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
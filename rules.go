@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// --- Scenario Rules ---
+
+const (
+	outcomeIssue  = "issue"  // Issue after Delay, or -issuance-delay if unset
+	outcomeFail   = "fail"   // Reject the enroll request
+	outcomeRevoke = "revoke" // Issue the order and revoke it right away
+)
+
+// ScenarioRule maps a CSR common name pattern to a fixed outcome, so tests
+// can pick behavior through the domain they enroll, e.g. slow.example.com
+// or fail.example.com. CN is a path.Match glob matched case-insensitively.
+type ScenarioRule struct {
+	CN         string `json:"cn"`
+	Outcome    string `json:"outcome"`
+	Delay      string `json:"delay,omitempty"`      // issue: duration before issuance
+	StatusCode int    `json:"statusCode,omitempty"` // fail: HTTP status (default 400)
+	Message    string `json:"message,omitempty"`    // fail: error message
+	Reason     string `json:"reason,omitempty"`     // revoke: revocation reason (default unspecified)
+
+	delay time.Duration
+}
+
+// loadScenarioRules reads a JSON array of rules from file.
+func loadScenarioRules(file string) ([]ScenarioRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ScenarioRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rule := &rules[i]
+		rule.CN = strings.ToLower(rule.CN)
+		if _, err := path.Match(rule.CN, ""); err != nil {
+			return nil, fmt.Errorf("rule %d: bad cn pattern %q: %w", i, rule.CN, err)
+		}
+		switch rule.Outcome {
+		case outcomeIssue:
+			if rule.Delay != "" {
+				if rule.delay, err = time.ParseDuration(rule.Delay); err != nil {
+					return nil, fmt.Errorf("rule %d: %w", i, err)
+				}
+			}
+		case outcomeFail:
+			if rule.StatusCode == 0 {
+				rule.StatusCode = http.StatusBadRequest
+			}
+			if rule.StatusCode < 400 || rule.StatusCode > 599 {
+				return nil, fmt.Errorf("rule %d: statusCode %d is not an error status (400-599)", i, rule.StatusCode)
+			}
+			if rule.Message == "" {
+				rule.Message = "Enrollment rejected by scenario rule"
+			}
+		case outcomeRevoke:
+			if rule.Reason == "" {
+				rule.Reason = "unspecified"
+			}
+			if !validRevocationReason(rule.Reason) {
+				return nil, fmt.Errorf("rule %d: unknown revocation reason %q", i, rule.Reason)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown outcome %q", i, rule.Outcome)
+		}
+	}
+	return rules, nil
+}
+
+// matchScenarioRule returns the first rule matching the CSR's common name.
+func (s *Server) matchScenarioRule(csr *x509.CertificateRequest) *ScenarioRule {
+	if len(s.ScenarioRules) == 0 || csr.Subject.CommonName == "" {
+		return nil
+	}
+	cn := strings.ToLower(csr.Subject.CommonName)
+	for i := range s.ScenarioRules {
+		if ok, _ := path.Match(s.ScenarioRules[i].CN, cn); ok {
+			return &s.ScenarioRules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+// --- Seeded Orders ---
+
+// SeedOrder describes an order to create at startup with -seed, so tests
+// can begin from a known state. Only ID and Status are required: without
+// a CSR one is generated for CommonName and SANs.
+type SeedOrder struct {
+	ID             int      `json:"id"`
+	Status         string   `json:"status"` // "pending", "issued", "held", "revoked" or "declined"
+	CommonName     string   `json:"commonName,omitempty"`
+	SANs           []string `json:"sans,omitempty"`
+	Csr            string   `json:"csr,omitempty"`
+	RequesterEmail string   `json:"requesterEmail,omitempty"`
+	ProductCode    int      `json:"productCode,omitempty"`
+	Term           int      `json:"term,omitempty"`
+	Chain          string   `json:"chain,omitempty"`
+	RevokeReason   string   `json:"revokeReason,omitempty"` // revoked: defaults to unspecified
+}
+
+// loadSeedOrders reads a JSON array of seed orders from file.
+func loadSeedOrders(file string) ([]SeedOrder, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var seeds []SeedOrder
+	if err := json.Unmarshal(data, &seeds); err != nil {
+		return nil, err
+	}
+	seen := make(map[int]bool)
+	for i, so := range seeds {
+		if so.ID <= 0 {
+			return nil, fmt.Errorf("order %d: id must be positive", i)
+		}
+		if seen[so.ID] {
+			return nil, fmt.Errorf("order %d: duplicate id %d", i, so.ID)
+		}
+		seen[so.ID] = true
+		switch so.Status {
+		case "pending", "issued", "held", "revoked", "declined":
+		default:
+			return nil, fmt.Errorf("order %d: unknown status %q", so.ID, so.Status)
+		}
+		if so.Csr == "" && so.CommonName == "" {
+			return nil, fmt.Errorf("order %d: commonName or csr is required", so.ID)
+		}
+		if so.RevokeReason != "" && !validRevocationReason(so.RevokeReason) {
+			return nil, fmt.Errorf("order %d: unknown revokeReason %q", so.ID, so.RevokeReason)
+		}
+	}
+	return seeds, nil
+}
+
+// seedOrdersLocked creates the orders in seeds and advances nextID past
+// them. Orders already loaded from -store-file are left as stored, so a
+// restart with both flags keeps what happened since. Pending orders go
+// through issuance like fresh ones. mu must be held for writing.
+func (s *Server) seedOrdersLocked(seeds []SeedOrder) (int, error) {
+	created := 0
+	for _, so := range seeds {
+		if so.ID >= s.nextID {
+			s.nextID = so.ID + 1
+		}
+		if s.orders[so.ID] != nil {
+			continue
+		}
+		o, err := s.seedOrder(so)
+		if err != nil {
+			return created, fmt.Errorf("order %d: %w", so.ID, err)
+		}
+		s.orders[o.ID] = o
+		created++
+
+		switch so.Status {
+		case "pending":
+			if s.Approval == approvalManual {
+				o.AwaitingApproval = true
+			} else if s.IssueAfterPolls == 0 {
+				id := o.ID
+				s.scheduleLocked(s.IssuanceDelay, func() { s.issueOrderLocked(id) })
+			}
+		case "declined":
+			o.Status = "declined"
+		default:
+			if !s.issueOrderLocked(o.ID) {
+				return created, fmt.Errorf("could not issue certificate")
+			}
+			if so.Status == "held" {
+				s.applyRevocationLocked(o, "held", reasonCertificateHold)
+			} else if so.Status == "revoked" {
+				s.applyRevocationLocked(o, "revoked", so.RevokeReason)
+			}
+		}
+	}
+	s.saveStoreLocked()
+	return created, nil
+}
+
+// seedOrder builds the pending order so describes.
+func (s *Server) seedOrder(so SeedOrder) (*Order, error) {
+	if so.Csr == "" {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate(so.CommonName, so.SANs), key)
+		if err != nil {
+			return nil, err
+		}
+		so.Csr = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	}
+	csr, err := parseCSRPEM(so.Csr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.ca.chain(so.Chain); !ok {
+		return nil, fmt.Errorf("unknown chain %q", so.Chain)
+	}
+	product, term, ok := s.lookupProduct(so.ProductCode, so.Term)
+	if !ok {
+		return nil, fmt.Errorf("invalid product or term")
+	}
+
+	now := time.Now()
+	return &Order{
+		ID:            so.ID,
+		OrderNumber:   formatOrderNumber(s.OrderNumberFormat, so.ID, now),
+		CSR:           so.Csr,
+		CommonName:    csr.Subject.CommonName,
+		SANs:          csrSANs(csr, product.Template),
+		Requester:     so.RequesterEmail,
+		Chain:         so.Chain,
+		ProductCode:   product.Code,
+		RequestedTerm: term,
+		Term:          term,
+		Status:        "pending",
+		CreatedAt:     now,
+		PendingSince:  now,
+		UpdatedAt:     now,
+	}, nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// --- Server ---
+
+// Options configures a Server. Each field corresponds to the command-line
+// flag of the same name; DefaultOptions returns the flag defaults.
+type Options struct {
+	EnableAdmin   bool          // Registers the /api/ssl/v1/admin/ endpoints
+	AdminToken    string        // Required in X-Admin-Token by the admin endpoints, if set
+	IssuanceDelay time.Duration // Time an order stays pending before issuance
+	ErrorFormat   string        // "plain" or "problem" (RFC 7807)
+
+	DisabledEndpoints map[string]bool // Endpoint names from -disable-endpoints
+	DisabledStatus    int             // Status returned by disabled endpoints
+
+	MaxRequestDuration time.Duration     // Requests running longer get a 504 (0 = no limit)
+	MaxValidityDays    int               // Caps the requested term (0 = no cap)
+	RenewalWindowDays  int               // Orders become renewable this close to expiry
+	AuthDelay          time.Duration     // Simulated login latency
+	OrderNumberFormat  string            // Template for orderNumber, see formatOrderNumber
+	IssueAfterPolls    int               // Issue on the Nth status poll instead of after a delay (0 = off)
+	Approval           string            // "auto", or "manual" to wait for /admin/approve
+	LatencySchedule    []latencyWindow   // Slow periods relative to server start
+	MovedPaths         map[string]string // Old path -> new path, see withMovedPaths
+	MovedStatus        int               // 307 or 308
+	CollectLag         time.Duration     // How long after issuance collect still says not ready
+	ReissueRevokes     bool              // Revoke the certificate a reissue replaces, as superseded
+	OrderTTL           time.Duration     // Completed orders are swept this long after finishing (0 = keep)
+	ValidationSteps    []validationStep  // Steps reported by /api/ssl/v1/validation/{id}
+	DebugAuth          bool              // Log received credentials (development only)
+	RevokeLag          time.Duration     // Delay between a successful revoke and the status flip
+	RevocationLag      time.Duration     // Delay between the status flip and the CRL listing the certificate
+	RequireCNInSANs    bool              // Reject CSRs whose CN is not also a DNS SAN
+	RejectSHA1         bool              // Reject CSRs signed with SHA-1
+	ScenarioRules      []ScenarioRule    // CN-keyed outcomes from -scenario-rules
+	CacheMaxAge        time.Duration     // Cache-Control max-age on cacheable responses (0 = no header)
+	CacheStale         time.Duration     // Cache-Control stale-while-revalidate window
+	CRLValidity        time.Duration     // Time from a CRL's thisUpdate to its nextUpdate
+	SessionTTL         time.Duration     // Lifetime of auth tokens (0 = never expire)
+	SKIMethod          string            // How SubjectKeyIdentifiers are derived, see subjectKeyID
+	BadCA              string            // Make the CA invalid on purpose: "expired" or "weak" ("" = valid)
+	EnrollErrorsOK     bool              // Answer enroll failures with 200 and an error body
+	RandomIDs          bool              // Assign random order IDs instead of sequential ones
+	DegradedComponents map[string]bool   // serviceComponents reported degraded
+	ErrorStatuses      map[int]int       // HTTP status per Sectigo error code, from -error-status
+	Catalog            []Product         // Orderable products; empty uses the built-in catalog
+	Chains             []string          // Intermediate variants from -chains
+	Chaos              ChaosConfig       // Initial chaos config; a nil Seed picks a random one
+	AllowHeaderFaults  bool              // Honor X-Mock-Fail request headers
+	LogFormat          string            // Request log lines: "text" or "json"
+	StoreFile          string            // Orders are loaded from and saved to this file (empty = memory only)
+	StoreGzip          bool              // Gzip StoreFile when saving; loading detects it either way
+	IdempotencyTTL     time.Duration     // How long enroll responses are replayed for a reused Idempotency-Key
+	StatusProgression  []statusStage     // Statuses a pending order reports before issuance (empty = "pending")
+	RateLimit          int               // Requests per minute per token or IP (0 = unlimited)
+	Seed               []SeedOrder       // Orders created at startup, from -seed
+	DCV                bool              // Hold enrolled orders until their domains are validated
+	CORSOrigins        []string          // Origins allowed cross-origin requests, "*" for any (empty = CORS off)
+	DayLength          time.Duration     // Wall-clock length of a day of certificate validity
+}
+
+// DefaultOptions returns the options the server runs with when no flags
+// are given.
+func DefaultOptions() Options {
+	steps, _ := parseValidationSteps(defaultValidationSteps)
+	return Options{
+		IssuanceDelay:     5 * time.Second,
+		ErrorFormat:       errorFormatPlain,
+		DisabledStatus:    http.StatusServiceUnavailable,
+		RenewalWindowDays: 90,
+		Approval:          approvalAuto,
+		OrderNumberFormat: "{id}",
+		MovedStatus:       http.StatusPermanentRedirect,
+		ValidationSteps:   steps,
+		SessionTTL:        time.Hour,
+		SKIMethod:         skiSHA1,
+		Catalog:           defaultCatalog,
+		Chaos:             ChaosConfig{Mode: chaosModeError},
+		LogFormat:         logFormatText,
+		IdempotencyTTL:    24 * time.Hour,
+		DayLength:         24 * time.Hour,
+		CRLValidity:       24 * time.Hour,
+	}
+}
+
+// firstOrderID is the sslId of the first sequential order.
+const firstOrderID = 12345
+
+// Server is one mock CA: its config, its orders and sessions, and the
+// background work acting on them. Handlers are methods on it, so several
+// servers can run side by side in one process, e.g. one per test with
+// httptest.NewServer(srv.Handler()).
+type Server struct {
+	Options
+
+	mu         sync.RWMutex
+	orders     map[int]*Order
+	sessions   map[string]*Session
+	injected   map[int]*Injection             // Fault injection by order ID
+	idempotent map[string]*idempotentResponse // Enroll responses by Idempotency-Key
+	nextID     int
+
+	ca         *mockCA
+	chaos      chaosState
+	background backgroundWork
+	startedAt  time.Time
+	ready      atomic.Bool // Store loaded and not shutting down, see /readyz
+	requestLog *slog.Logger
+	metrics    metrics
+	limiter    *rateLimiter // Nil unless RateLimit is set
+	crl        crlState
+	done       chan struct{} // Closed by Close to stop the sweeper
+}
+
+// NewServer validates opts, creates the mock CA, loads opts.StoreFile, if
+// set, and creates the opts.Seed orders. The server starts expiring
+// certificates right away, and sweeping when OrderTTL is set; call Close
+// to stop it.
+func NewServer(opts Options) (*Server, error) {
+	if opts.ErrorFormat != errorFormatPlain && opts.ErrorFormat != errorFormatProblem {
+		return nil, fmt.Errorf("invalid -error-format %q (want %s or %s)", opts.ErrorFormat, errorFormatPlain, errorFormatProblem)
+	}
+	if opts.DisabledStatus != http.StatusNotFound && opts.DisabledStatus != http.StatusServiceUnavailable {
+		return nil, fmt.Errorf("invalid -disabled-status %d (want 404 or 503)", opts.DisabledStatus)
+	}
+	for name := range opts.DisabledEndpoints {
+		switch name {
+		case "auth", "ca", "enroll", "status", "collect", "revoke", "revoked", "unhold", "order", "orders", "changes", "products", "validation", "renew", "dcv", "reissue", "crl":
+		default:
+			return nil, fmt.Errorf("invalid -disable-endpoints entry %q", name)
+		}
+	}
+	if opts.IssuanceDelay < 0 {
+		return nil, fmt.Errorf("invalid -issuance-delay %s: must not be negative", opts.IssuanceDelay)
+	}
+	if opts.SessionTTL < 0 {
+		return nil, fmt.Errorf("invalid -session-ttl %s: must not be negative", opts.SessionTTL)
+	}
+	if opts.RateLimit < 0 {
+		return nil, fmt.Errorf("invalid -rate-limit %d: must not be negative", opts.RateLimit)
+	}
+	if opts.IdempotencyTTL <= 0 {
+		return nil, fmt.Errorf("invalid -idempotency-ttl %s: must be positive", opts.IdempotencyTTL)
+	}
+	if opts.DayLength <= 0 {
+		return nil, fmt.Errorf("invalid -day-length %s: must be positive", opts.DayLength)
+	}
+	if opts.CRLValidity <= 0 {
+		return nil, fmt.Errorf("invalid -crl-validity %s: must be positive", opts.CRLValidity)
+	}
+	if opts.CacheMaxAge < 0 || opts.CacheStale < 0 {
+		return nil, fmt.Errorf("invalid cache durations: -cache-max-age and -cache-stale-while-revalidate must not be negative")
+	}
+	if opts.Approval != approvalAuto && opts.Approval != approvalManual {
+		return nil, fmt.Errorf("invalid -approval %q (want %s or %s)", opts.Approval, approvalAuto, approvalManual)
+	}
+	if opts.LogFormat != logFormatText && opts.LogFormat != logFormatJSON {
+		return nil, fmt.Errorf("invalid -log-format %q (want %s or %s)", opts.LogFormat, logFormatText, logFormatJSON)
+	}
+	if opts.MovedStatus != http.StatusTemporaryRedirect && opts.MovedStatus != http.StatusPermanentRedirect {
+		return nil, fmt.Errorf("invalid -moved-status %d (want 307 or 308)", opts.MovedStatus)
+	}
+	if opts.SKIMethod != skiSHA1 && opts.SKIMethod != skiSHA256 {
+		return nil, fmt.Errorf("invalid -ski-method %q (want %s or %s)", opts.SKIMethod, skiSHA1, skiSHA256)
+	}
+	if opts.BadCA != "" && opts.BadCA != badCAExpired && opts.BadCA != badCAWeak {
+		return nil, fmt.Errorf("invalid -bad-ca %q (want %s or %s)", opts.BadCA, badCAExpired, badCAWeak)
+	}
+	for i, name := range opts.Chains {
+		if slices.Contains(opts.Chains[:i], name) {
+			return nil, fmt.Errorf("invalid -chains: duplicate variant %q", name)
+		}
+	}
+	for name := range opts.DegradedComponents {
+		if !slices.Contains(serviceComponents, name) {
+			return nil, fmt.Errorf("invalid -degraded-components entry %q", name)
+		}
+	}
+	if len(opts.Catalog) == 0 {
+		opts.Catalog = defaultCatalog
+	}
+	if err := validateChaosConfig(&opts.Chaos); err != nil {
+		return nil, fmt.Errorf("invalid chaos flags: %v", err)
+	}
+	if opts.Chaos.Seed == nil {
+		seed := rand.Uint64()
+		opts.Chaos.Seed = &seed
+	}
+
+	ca, err := newMockCA(opts.Chains, opts.SKIMethod, opts.BadCA)
+	if err != nil {
+		return nil, fmt.Errorf("creating mock CA: %w", err)
+	}
+
+	s := &Server{
+		Options:    opts,
+		orders:     make(map[int]*Order),
+		sessions:   make(map[string]*Session),
+		injected:   make(map[int]*Injection),
+		idempotent: make(map[string]*idempotentResponse),
+		nextID:     firstOrderID,
+		ca:         ca,
+		chaos:      chaosState{cfg: opts.Chaos, rng: rand.New(rand.NewPCG(*opts.Chaos.Seed, *opts.Chaos.Seed))},
+		background: backgroundWork{timers: make(map[*time.Timer]struct{})},
+		startedAt:  time.Now(),
+		requestLog: newRequestLogger(opts.LogFormat),
+		metrics:    metrics{requests: make(map[requestKey]uint64), latency: make(map[string]*histogram)},
+		done:       make(chan struct{}),
+	}
+
+	if s.RateLimit > 0 {
+		s.limiter = newRateLimiter(s.RateLimit)
+	}
+
+	if s.StoreFile != "" {
+		n, err := s.loadStore(s.StoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading -store-file: %w", err)
+		}
+		log.Printf("Loaded %d orders from %s", n, s.StoreFile)
+	}
+	if len(s.Seed) > 0 {
+		s.mu.Lock()
+		n, err := s.seedOrdersLocked(s.Seed)
+		s.mu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("seeding orders: %w", err)
+		}
+		log.Printf("Seeded %d orders", n)
+	}
+	go s.runExpirer()
+	if s.OrderTTL > 0 {
+		go s.runSweeper(s.OrderTTL)
+	}
+	s.ready.Store(true)
+	return s, nil
+}
+
+// Handler returns the API, with the admin endpoints if EnableAdmin is set.
+// Routes added here belong in openapi.json too.
+// It accepts HTTP/2 over cleartext (prior knowledge or Upgrade: h2c)
+// alongside plain HTTP/1.1.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ssl/v1/ping", s.handlePing) // Not wrapped: must stay lock-free
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	mux.HandleFunc("/api/ssl/v1/user/auth", s.endpoint("auth", s.handleAuth))
+	mux.HandleFunc("/api/ssl/v1/ca", s.endpoint("ca", s.handleCA))
+	mux.HandleFunc("/api/ssl/v1/trust-bundle", s.endpoint("ca", s.handleTrustBundle))
+	mux.HandleFunc("/api/ssl/v1/intermediate", s.endpoint("ca", s.handleIntermediate))
+	mux.HandleFunc("/api/ssl/v1/crl", s.endpoint("crl", s.handleCRL))
+	mux.HandleFunc("/api/ssl/v1/crl/info", s.endpoint("crl", s.handleCRLInfo))
+	mux.HandleFunc("/api/ssl/v1/crl/signer", s.endpoint("crl", s.handleCRLSigner))
+	mux.HandleFunc("/api/ssl/v1/enroll", s.endpoint("enroll", s.handleEnroll))
+	mux.HandleFunc("/api/ssl/v1/renew", s.endpoint("renew", s.handleRenew))
+	mux.HandleFunc("/api/ssl/v1/reissue", s.endpoint("reissue", s.handleReissue))
+	mux.HandleFunc("/api/ssl/v1/status/{id}", s.endpoint("status", s.handleStatus))
+	mux.HandleFunc("/api/ssl/v1/status/{$}", s.endpoint("status", s.handleStatus)) // Missing ID: 400, not 404
+	mux.HandleFunc("/api/ssl/v1/status/service", s.endpoint("status", s.handleServiceStatus))
+	mux.HandleFunc("/api/ssl/v1/collect/{id}", s.endpoint("collect", s.handleCollect))
+	mux.HandleFunc("/api/ssl/v1/collect/{$}", s.endpoint("collect", s.handleCollect))
+	mux.HandleFunc("/api/ssl/v1/revoke", s.endpoint("revoke", s.handleRevoke))
+	mux.HandleFunc("/api/ssl/v1/revoke/bulk", s.endpoint("revoke", s.handleRevokeBulk))
+	mux.HandleFunc("/api/ssl/v1/revoked", s.endpoint("revoked", s.handleRevoked))
+	mux.HandleFunc("/api/ssl/v1/revocation-summary", s.endpoint("revoked", s.handleRevocationSummary))
+	mux.HandleFunc("/api/ssl/v1/unhold/{id}", s.endpoint("unhold", s.handleUnhold))
+	mux.HandleFunc("/api/ssl/v1/order/{id}/{action}", s.endpoint("order", s.handleOrder))
+	mux.HandleFunc("/api/ssl/v1/orders", s.endpoint("orders", s.handleOrders))
+	mux.HandleFunc("/api/ssl/v1/changes", s.endpoint("changes", s.handleChanges))
+	mux.HandleFunc("/api/ssl/v1/products", s.endpoint("products", s.handleProducts))
+	mux.HandleFunc("/api/ssl/v1/validation/{id}", s.endpoint("validation", s.handleValidation))
+	mux.HandleFunc("/api/ssl/v1/dcv/validate", s.endpoint("dcv", s.handleDCVValidate))
+	mux.HandleFunc("/api/ssl/v1/dcv/pending", s.endpoint("dcv", s.handleDCVPending))
+
+	if s.EnableAdmin {
+		mux.HandleFunc("/api/ssl/v1/admin/approve", s.adminEndpoint(s.handleAdminApprove))
+		mux.HandleFunc("/api/ssl/v1/admin/chaos", s.adminEndpoint(s.handleAdminChaos))
+		mux.HandleFunc("/api/ssl/v1/admin/config", s.adminEndpoint(s.handleAdminConfig))
+		mux.HandleFunc("/api/ssl/v1/admin/gen-csr", s.adminEndpoint(s.handleAdminGenCSR))
+		mux.HandleFunc("/api/ssl/v1/admin/inject/{id}", s.adminEndpoint(s.handleAdminInject))
+		mux.HandleFunc("/api/ssl/v1/admin/issue/{id}", s.adminEndpoint(s.handleAdminIssue))
+		mux.HandleFunc("/api/ssl/v1/admin/logs", s.adminEndpoint(s.handleAdminLogs))
+		mux.HandleFunc("/api/ssl/v1/admin/reject", s.adminEndpoint(s.handleAdminReject))
+		mux.HandleFunc("/api/ssl/v1/admin/reset", s.adminEndpoint(s.handleAdminReset))
+		mux.HandleFunc("/api/ssl/v1/admin/sessions/{token}/expire", s.adminEndpoint(s.handleAdminSessions))
+	}
+	mux.HandleFunc("/", withLanguage(s.handleNotFound)) // JSON instead of the mux's plain-text 404
+
+	var handler http.Handler = mux
+	if len(s.MovedPaths) > 0 {
+		handler = withMovedPaths(handler, s.MovedPaths, s.MovedStatus)
+	}
+	if len(s.CORSOrigins) > 0 {
+		handler = withCORS(handler, s.CORSOrigins)
+	}
+	handler = withRequestLog(handler, s.requestLog)
+	return h2c.NewHandler(handler, &http2.Server{})
+}
+
+// Drain makes /readyz fail so load balancers stop sending traffic, ahead
+// of shutting the HTTP server down.
+func (s *Server) Drain() {
+	s.ready.Store(false)
+}
+
+// Close stops the expirer and sweeper, cancels scheduled order changes,
+// then saves the store. It returns how many changes were cancelled.
+func (s *Server) Close() int {
+	close(s.done)
+	s.mu.Lock()
+	n := s.stopBackgroundLocked()
+	s.saveStoreLocked()
+	s.mu.Unlock()
+	return n
+}
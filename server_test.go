@@ -0,0 +1,997 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mock-setigo/client"
+)
+
+// BenchmarkEnrollParallel enrolls from GOMAXPROCS goroutines at once and
+// then checks that every enroll got its own order. Run it with -race to
+// check the locking as well as the throughput, e.g.
+//
+//	go test -run '^$' -bench EnrollParallel -race
+func BenchmarkEnrollParallel(b *testing.B) {
+	// Logging every order would dominate the numbers.
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	for _, bc := range []struct {
+		name  string
+		delay time.Duration // 0 signs inside enroll, under the lock
+	}{
+		{"pending", time.Hour},
+		{"issued", 0},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.IssuanceDelay = bc.delay
+			srv, err := NewServer(opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer srv.Close()
+			h := srv.Handler()
+			token := benchToken(b, h)
+			body := benchEnrollBody(b)
+
+			ids := make(chan int, b.N)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					req := httptest.NewRequest(http.MethodPost, "/api/ssl/v1/enroll", strings.NewReader(body))
+					req.Header.Set("token", token)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+					var resp EnrollResponse
+					if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+						b.Errorf("enroll: %d %s", rec.Code, rec.Body)
+						return
+					}
+					ids <- resp.SslId
+				}
+			})
+			b.StopTimer()
+			close(ids)
+
+			seen := make(map[int]bool, b.N)
+			for id := range ids {
+				if seen[id] {
+					b.Fatalf("order ID %d was handed out twice", id)
+				}
+				seen[id] = true
+			}
+			srv.mu.RLock()
+			stored := len(srv.orders)
+			srv.mu.RUnlock()
+			if stored != len(seen) {
+				b.Fatalf("%d enrolls succeeded but %d orders are stored", len(seen), stored)
+			}
+			b.ReportMetric(float64(len(seen))/b.Elapsed().Seconds(), "orders/s")
+		})
+	}
+}
+
+// TestClient drives an order through its life with the client package.
+func TestClient(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	opts := DefaultOptions()
+	opts.IssuanceDelay = 0
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := client.New(ts.URL)
+	if _, err := c.Authenticate(ctx, "test", "x"); err != nil {
+		t.Fatal(err)
+	}
+	var req EnrollRequest
+	if err := json.Unmarshal([]byte(benchEnrollBody(t)), &req); err != nil {
+		t.Fatal(err)
+	}
+	order, err := c.Enroll(ctx, client.EnrollRequest{Csr: req.Csr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := c.Status(ctx, order.SslId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != "issued" || st.CommonName != "bench.example.com" || st.ExpiresAt.IsZero() {
+		t.Errorf("status = %+v, want issued bench.example.com with an expiry", st)
+	}
+	certPEM, err := c.Collect(ctx, order.SslId, "x509CO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(certPEM); block == nil || block.Type != "CERTIFICATE" {
+		t.Errorf("collect returned %q, want a PEM certificate", certPEM)
+	}
+
+	if err := c.Revoke(ctx, order.SslId, "keyCompromise"); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := c.Status(ctx, order.SslId); err != nil || st.Status != "revoked" {
+		t.Errorf("status after revoke = %+v, %v; want revoked", st, err)
+	}
+	if err := c.Revoke(ctx, order.SslId, ""); err == nil {
+		t.Error("revoking twice succeeded")
+	}
+
+	var apiErr *client.Error
+	if _, err := c.Status(ctx, 1); !errors.As(err, &apiErr) || apiErr.Code != errCodeNotFound {
+		t.Errorf("status of unknown order: %v, want code %d", err, errCodeNotFound)
+	}
+	if _, err := client.New(ts.URL, client.WithToken("bogus")).Status(ctx, order.SslId); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status with a bogus token: %v, want HTTP 401", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Status(cancelled, order.SslId); !errors.Is(err, context.Canceled) {
+		t.Errorf("status with a cancelled context: %v, want context.Canceled", err)
+	}
+}
+
+// TestInvalidOrderID checks that every route taking an order ID rejects
+// one with trailing garbage instead of acting on its numeric prefix.
+func TestInvalidOrderID(t *testing.T) {
+	opts := DefaultOptions()
+	opts.EnableAdmin = true
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/api/ssl/v1/status/12345xyz"},
+		{http.MethodGet, "/api/ssl/v1/collect/12345xyz"},
+		{http.MethodPost, "/api/ssl/v1/unhold/12345xyz"},
+		{http.MethodGet, "/api/ssl/v1/order/12345xyz/renewable"},
+		{http.MethodGet, "/api/ssl/v1/validation/12345xyz"},
+		{http.MethodPost, "/api/ssl/v1/admin/inject/12345xyz"},
+		{http.MethodPost, "/api/ssl/v1/admin/issue/12345xyz"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}"))
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: got %d %s, want 400", tc.method, tc.path, rec.Code, rec.Body)
+		}
+	}
+}
+
+// TestNotFound pins the 404 bodies clients branch on: Sectigo's code -40
+// for an unknown order, and the same shape for a path with no route.
+func TestNotFound(t *testing.T) {
+	srv, err := NewServer(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+
+	for _, tc := range []struct{ path, lang, want string }{
+		{"/api/ssl/v1/status/99999", "", `{"code":-40,"message":"Order not found"}` + "\n"},
+		{"/api/ssl/v1/status/99999", "de", `{"code":-40,"message":"Auftrag nicht gefunden"}` + "\n"},
+		{"/api/ssl/v1/no-such-route", "", `{"code":-40,"message":"Not found"}` + "\n"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("token", token)
+		if tc.lang != "" {
+			req.Header.Set("Accept-Language", tc.lang)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound || rec.Body.String() != tc.want {
+			t.Errorf("GET %s: got %d %q, want 404 %q", tc.path, rec.Code, rec.Body, tc.want)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("GET %s: Content-Type %q, want application/json", tc.path, ct)
+		}
+	}
+}
+
+// TestSessionTTL checks that a token is accepted until just before
+// CreatedAt+SessionTTL and rejected from that instant on.
+func TestSessionTTL(t *testing.T) {
+	opts := DefaultOptions()
+	opts.SessionTTL = 300 * time.Millisecond
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+
+	srv.mu.RLock()
+	sess := *srv.sessions[token]
+	srv.mu.RUnlock()
+	deadline := sess.CreatedAt.Add(opts.SessionTTL)
+	if !sess.ExpiresAt.Equal(deadline) {
+		t.Fatalf("ExpiresAt = %v, want CreatedAt+TTL %v", sess.ExpiresAt, deadline)
+	}
+	if !sess.validAt(deadline.Add(-time.Nanosecond)) {
+		t.Error("session invalid just before CreatedAt+TTL")
+	}
+	if sess.validAt(deadline) {
+		t.Error("session still valid at CreatedAt+TTL")
+	}
+
+	status := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/ssl/v1/revoked", nil)
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	time.Sleep(time.Until(deadline.Add(-100 * time.Millisecond)))
+	if got := status(); got != http.StatusOK {
+		t.Errorf("just before expiry: got %d, want 200", got)
+	}
+	time.Sleep(time.Until(deadline))
+	if got := status(); got != http.StatusUnauthorized {
+		t.Errorf("at expiry: got %d, want 401", got)
+	}
+}
+
+// TestResetSerials checks that an order ID reused after /admin/reset gets
+// a certificate serial no earlier order had.
+func TestResetSerials(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	opts := DefaultOptions()
+	opts.EnableAdmin = true
+	opts.IssuanceDelay = 0
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+	body := benchEnrollBody(t)
+
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	seen := make(map[string]bool)
+	for round := range 3 {
+		rec := serve("/api/ssl/v1/enroll", body)
+		var resp EnrollResponse
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+			t.Fatalf("round %d: enroll: %d %s", round, rec.Code, rec.Body)
+		}
+		if resp.SslId != firstOrderID {
+			t.Fatalf("round %d: sslId %d, want the reused %d", round, resp.SslId, firstOrderID)
+		}
+		srv.mu.RLock()
+		serial, ok := certSerial(srv.orders[resp.SslId].Certificate)
+		srv.mu.RUnlock()
+		if !ok {
+			t.Fatalf("round %d: order %d was not issued", round, resp.SslId)
+		}
+		if seen[serial] {
+			t.Fatalf("round %d: serial %s was issued before the reset", round, serial)
+		}
+		seen[serial] = true
+
+		if rec := serve("/api/ssl/v1/admin/reset", ""); rec.Code != http.StatusOK {
+			t.Fatalf("round %d: reset: %d %s", round, rec.Code, rec.Body)
+		}
+	}
+	// Random serials rarely collide anyway; what matters is that the reset
+	// did not forget them.
+	srv.ca.serialMu.Lock()
+	defer srv.ca.serialMu.Unlock()
+	for serial := range seen {
+		if !srv.ca.serials[serial] {
+			t.Errorf("serial %s is no longer reserved after the reset", serial)
+		}
+	}
+}
+
+// TestReissueSerial checks that a reissue keeps the order ID and subject
+// but issues a certificate with a new serial, that status reports the
+// replaced serial, and that -reissue-revokes-superseded lists it as
+// revoked.
+func TestReissueSerial(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	opts := DefaultOptions()
+	opts.IssuanceDelay = 0
+	opts.ReissueRevokes = true
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	status := func(id int) map[string]any {
+		rec := serve(http.MethodGet, "/api/ssl/v1/status/"+strconv.Itoa(id), "")
+		var st map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+			t.Fatalf("status: %d %s", rec.Code, rec.Body)
+		}
+		return st
+	}
+
+	rec := serve(http.MethodPost, "/api/ssl/v1/enroll", benchEnrollBody(t))
+	var enrolled EnrollResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &enrolled); err != nil {
+		t.Fatalf("enroll: %d %s", rec.Code, rec.Body)
+	}
+	before := status(enrolled.SslId)
+
+	var fresh EnrollRequest // Same subject, new key
+	json.Unmarshal([]byte(benchEnrollBody(t)), &fresh)
+	body, _ := json.Marshal(map[string]any{"sslId": enrolled.SslId, "csr": fresh.Csr})
+	if rec := serve(http.MethodPost, "/api/ssl/v1/reissue", string(body)); rec.Code != http.StatusOK {
+		t.Fatalf("reissue: %d %s", rec.Code, rec.Body)
+	}
+	after := status(enrolled.SslId)
+
+	if after["status"] != "issued" || after["commonName"] != before["commonName"] {
+		t.Errorf("after reissue: status %v, commonName %v; want issued, %v", after["status"], after["commonName"], before["commonName"])
+	}
+	oldSerial, newSerial := before["serialNumber"], after["serialNumber"]
+	if oldSerial == nil || newSerial == nil || oldSerial == newSerial {
+		t.Errorf("serial %v before and %v after reissue, want two different ones", oldSerial, newSerial)
+	}
+	if got, _ := after["supersededSerials"].([]any); len(got) != 1 || got[0] != oldSerial {
+		t.Errorf("supersededSerials = %v, want [%v]", after["supersededSerials"], oldSerial)
+	}
+
+	var revoked []RevokedEntry
+	json.Unmarshal(serve(http.MethodGet, "/api/ssl/v1/revoked", "").Body.Bytes(), &revoked)
+	if len(revoked) != 1 || revoked[0].Serial != oldSerial || revoked[0].Reason != reasonSuperseded || revoked[0].SslId != enrolled.SslId {
+		t.Errorf("revoked = %+v, want only serial %v of order %d, superseded", revoked, oldSerial, enrolled.SslId)
+	}
+}
+
+// TestHoldUnhold checks that only issued certificates can be put on hold,
+// and that one released by unhold can be collected again.
+func TestHoldUnhold(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	for _, tc := range []struct {
+		name       string
+		delay      time.Duration
+		wantHold   int
+		wantStatus string
+	}{
+		{"issued", 0, http.StatusOK, "issued"},
+		{"pending", time.Hour, http.StatusConflict, "pending"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.IssuanceDelay = tc.delay
+			srv, err := NewServer(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			h := srv.Handler()
+			token := benchToken(t, h)
+			serve := func(method, path, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, path, strings.NewReader(body))
+				req.Header.Set("token", token)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec := serve(http.MethodPost, "/api/ssl/v1/enroll", benchEnrollBody(t))
+			var enrolled EnrollResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &enrolled); err != nil {
+				t.Fatalf("enroll: %d %s", rec.Code, rec.Body)
+			}
+			id := strconv.Itoa(enrolled.SslId)
+			rec = serve(http.MethodPost, "/api/ssl/v1/revoke", `{"sslId":"`+id+`","reason":"certificateHold"}`)
+			if rec.Code != tc.wantHold {
+				t.Fatalf("hold: got %d %s, want %d", rec.Code, rec.Body, tc.wantHold)
+			}
+			if tc.wantHold != http.StatusOK {
+				srv.mu.RLock()
+				status := srv.orders[enrolled.SslId].Status
+				srv.mu.RUnlock()
+				if status != tc.wantStatus {
+					t.Errorf("after refused hold: status %q, want %q", status, tc.wantStatus)
+				}
+				return
+			}
+
+			if rec := serve(http.MethodPost, "/api/ssl/v1/unhold/"+id, ""); rec.Code != http.StatusOK {
+				t.Fatalf("unhold: %d %s", rec.Code, rec.Body)
+			}
+			rec = serve(http.MethodGet, "/api/ssl/v1/collect/"+id+"?format=x509", "")
+			if block, _ := pem.Decode(rec.Body.Bytes()); rec.Code != http.StatusOK || block == nil {
+				t.Fatalf("collect after unhold: %d %q", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// TestCRL checks that the CRL lists a revoked certificate with its reason
+// and is only re-signed, with a higher number, when revocations change.
+func TestCRL(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	opts := DefaultOptions()
+	opts.IssuanceDelay = 0
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	crl := func() *x509.RevocationList {
+		rec := serve(http.MethodGet, "/api/ssl/v1/crl", "")
+		list, err := x509.ParseRevocationList(rec.Body.Bytes())
+		if err != nil {
+			t.Fatalf("crl: %d %v", rec.Code, err)
+		}
+		if err := list.CheckSignatureFrom(srv.ca.issuer); err != nil {
+			t.Fatalf("crl signature: %v", err)
+		}
+		return list
+	}
+
+	rec := serve(http.MethodPost, "/api/ssl/v1/enroll", benchEnrollBody(t))
+	var enrolled EnrollResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &enrolled); err != nil {
+		t.Fatalf("enroll: %d %s", rec.Code, rec.Body)
+	}
+	first := crl()
+	if again := crl(); again.Number.Cmp(first.Number) != 0 || len(first.RevokedCertificateEntries) != 0 {
+		t.Fatalf("unchanged CRL: number %v then %v, %d entries", first.Number, again.Number, len(first.RevokedCertificateEntries))
+	}
+
+	id := strconv.Itoa(enrolled.SslId)
+	if rec := serve(http.MethodPost, "/api/ssl/v1/revoke", `{"sslId":"`+id+`","reason":"keyCompromise"}`); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", rec.Code, rec.Body)
+	}
+	srv.mu.RLock()
+	serial, _ := certSerial(srv.orders[enrolled.SslId].Certificate)
+	srv.mu.RUnlock()
+	after := crl()
+	if after.Number.Cmp(first.Number) <= 0 {
+		t.Errorf("CRL number %v after revoke, want more than %v", after.Number, first.Number)
+	}
+	if len(after.RevokedCertificateEntries) != 1 || after.RevokedCertificateEntries[0].SerialNumber.Text(16) != serial || after.RevokedCertificateEntries[0].ReasonCode != 1 {
+		t.Errorf("entries %+v, want serial %s with keyCompromise (1)", after.RevokedCertificateEntries, serial)
+	}
+
+	var info CRLInfo
+	json.Unmarshal(serve(http.MethodGet, "/api/ssl/v1/crl/info", "").Body.Bytes(), &info)
+	if info.CRLNumber != after.Number.Int64() || !info.NextUpdate.Equal(after.NextUpdate) {
+		t.Errorf("info %+v does not describe CRL %v (nextUpdate %v)", info, after.Number, after.NextUpdate)
+	}
+
+	// -revocation-lag keeps the revocation off the CRL until it passes.
+	srv.RevocationLag = time.Hour
+	for _, tc := range []struct {
+		at   time.Duration
+		want int
+	}{{59 * time.Minute, 0}, {61 * time.Minute, 1}} {
+		_, der, err := srv.currentCRL(time.Now().Add(tc.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, _ := x509.ParseRevocationList(der)
+		if got := len(list.RevokedCertificateEntries); got != tc.want {
+			t.Errorf("%v after revoke with a 1h lag: %d entries, want %d", tc.at, got, tc.want)
+		}
+	}
+}
+
+// TestReissueRestartsProgression checks that a reissued order walks
+// through the status progression and validation steps again, instead of
+// jumping to their end because it was enrolled long ago.
+func TestReissueRestartsProgression(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	opts := DefaultOptions()
+	opts.IssuanceDelay = time.Hour
+	opts.StatusProgression, _ = parseStatusProgression("applied=10m,requested=10m")
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/api/ssl/v1/enroll", benchEnrollBody(t))
+	var enrolled EnrollResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &enrolled); err != nil {
+		t.Fatalf("enroll: %d %s", rec.Code, rec.Body)
+	}
+	srv.mu.Lock()
+	o := srv.orders[enrolled.SslId]
+	o.CreatedAt = o.CreatedAt.AddDate(0, 0, -3)
+	o.PendingSince = o.CreatedAt
+	srv.issueOrderLocked(o.ID)
+	srv.mu.Unlock()
+
+	var fresh EnrollRequest
+	json.Unmarshal([]byte(benchEnrollBody(t)), &fresh)
+	body, _ := json.Marshal(map[string]any{"sslId": enrolled.SslId, "csr": fresh.Csr})
+	if rec := serve(http.MethodPost, "/api/ssl/v1/reissue", string(body)); rec.Code != http.StatusOK {
+		t.Fatalf("reissue: %d %s", rec.Code, rec.Body)
+	}
+
+	srv.mu.RLock()
+	status := srv.reportedStatus(o, time.Now())
+	steps := srv.validationProgress(o, time.Now())
+	srv.mu.RUnlock()
+	if status != "applied" {
+		t.Errorf("status after reissue = %q, want the first stage, applied", status)
+	}
+	if steps[0].Status != stepInProgress {
+		t.Errorf("first validation step after reissue = %q, want %q", steps[0].Status, stepInProgress)
+	}
+}
+
+// TestAdminExpireSession checks that the admin endpoint expires exactly
+// the token in its path.
+func TestAdminExpireSession(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	opts := DefaultOptions()
+	opts.EnableAdmin = true
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token, other := benchToken(t, h), benchToken(t, h)
+	serve := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/api/ssl/v1/admin/sessions/" + token + "/expire", http.StatusOK},
+		{"/api/ssl/v1/admin/sessions/unknown/expire", http.StatusNotFound},
+		{"/api/ssl/v1/admin/sessions/" + other + "/renew", http.StatusNotFound},
+	} {
+		if got := serve(http.MethodPost, tc.path, ""); got != tc.want {
+			t.Errorf("POST %s: got %d, want %d", tc.path, got, tc.want)
+		}
+	}
+	if got := serve(http.MethodGet, "/api/ssl/v1/revoked", token); got != http.StatusUnauthorized {
+		t.Errorf("expired token: got %d, want 401", got)
+	}
+	if got := serve(http.MethodGet, "/api/ssl/v1/revoked", other); got != http.StatusOK {
+		t.Errorf("other token: got %d, want 200", got)
+	}
+}
+
+// TestWildcardSANs checks that an order of a wildcard product reports the
+// same SANs as its certificate carries.
+func TestWildcardSANs(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	opts := DefaultOptions()
+	opts.IssuanceDelay = 0
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate("wild.example.com", nil), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(EnrollRequest{Csr: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), ProductCode: 288})
+	req := httptest.NewRequest(http.MethodPost, "/api/ssl/v1/enroll", strings.NewReader(string(body)))
+	req.Header.Set("token", token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var enrolled EnrollResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &enrolled); err != nil {
+		t.Fatalf("enroll: %d %s", rec.Code, rec.Body)
+	}
+
+	srv.mu.RLock()
+	o := *srv.orders[enrolled.SslId]
+	srv.mu.RUnlock()
+	block, _ := pem.Decode([]byte(o.Certificate))
+	if block == nil {
+		t.Fatalf("order %d was not issued", o.ID)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"*.wild.example.com", "wild.example.com"}
+	if !slices.Equal(cert.DNSNames, want) || !slices.Equal(o.SANs, cert.DNSNames) {
+		t.Errorf("certificate DNS names %v, order SANs %v, want both %v", cert.DNSNames, o.SANs, want)
+	}
+}
+
+// TestResetCancelsBackground checks that reset neither waits for a
+// webhook delivery in flight nor lets a revocation scheduled before it
+// act on the order reusing the ID afterwards.
+func TestResetCancelsBackground(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	hang := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer hook.Close()
+	defer close(hang)
+
+	opts := DefaultOptions()
+	opts.EnableAdmin = true
+	opts.IssuanceDelay = 0
+	opts.RevokeLag = 50 * time.Millisecond
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	enroll := func(callbackURL string) int {
+		var req EnrollRequest
+		json.Unmarshal([]byte(benchEnrollBody(t)), &req)
+		req.CallbackURL = callbackURL
+		body, _ := json.Marshal(req)
+		rec := serve(http.MethodPost, "/api/ssl/v1/enroll", string(body))
+		var enrolled EnrollResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &enrolled); err != nil || enrolled.SslId == 0 {
+			t.Fatalf("enroll: %d %s", rec.Code, rec.Body)
+		}
+		return enrolled.SslId
+	}
+
+	id := enroll(hook.URL)
+	if rec := serve(http.MethodPost, "/api/ssl/v1/revoke", `{"sslId":"`+strconv.Itoa(id)+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", rec.Code, rec.Body)
+	}
+
+	start := time.Now()
+	if rec := serve(http.MethodPost, "/api/ssl/v1/admin/reset", ""); rec.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", rec.Code, rec.Body)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("reset took %s, waiting for the webhook", d)
+	}
+
+	if again := enroll(""); again != id {
+		t.Fatalf("after reset: got ID %d, want %d", again, id)
+	}
+	time.Sleep(2 * opts.RevokeLag)
+	srv.mu.RLock()
+	status := srv.orders[id].Status
+	srv.mu.RUnlock()
+	if status != "issued" {
+		t.Errorf("after reset: status %q, want issued", status)
+	}
+}
+
+// TestIdempotencyKey checks that an enroll retried with the same
+// Idempotency-Key replays the first response instead of creating another
+// order, and that reusing the key with another body is refused.
+func TestIdempotencyKey(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	srv, err := NewServer(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	token := benchToken(t, h)
+	enroll := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/ssl/v1/enroll", strings.NewReader(body))
+		req.Header.Set("token", token)
+		req.Header.Set(idempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := benchEnrollBody(t)
+	first := enroll("k1", body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("enroll: %d %s", first.Code, first.Body)
+	}
+	if got := first.Header().Get(idempotentReplayHeader); got != "" {
+		t.Errorf("first enroll: %s %q, want none", idempotentReplayHeader, got)
+	}
+	retry := enroll("k1", body)
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry: got %d %s, want %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if got := retry.Header().Get(idempotentReplayHeader); got != "true" {
+		t.Errorf("retry: %s %q, want true", idempotentReplayHeader, got)
+	}
+	srv.mu.RLock()
+	orders := len(srv.orders)
+	srv.mu.RUnlock()
+	if orders != 1 {
+		t.Errorf("after retry: %d orders, want 1", orders)
+	}
+
+	if rec := enroll("k1", benchEnrollBody(t)); rec.Code != http.StatusConflict {
+		t.Errorf("reused key, other body: got %d %s, want %d", rec.Code, rec.Body, http.StatusConflict)
+	}
+	if rec := enroll("k2", body); rec.Code != http.StatusOK || rec.Body.String() == first.Body.String() {
+		t.Errorf("other key: got %d %s, want a new order", rec.Code, rec.Body)
+	}
+}
+
+// TestRateLimit checks that a token exceeding -rate-limit gets 429 with
+// Retry-After and the JSON error body, while other tokens keep going.
+func TestRateLimit(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	opts := DefaultOptions()
+	opts.RateLimit = 3
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	h := srv.Handler()
+	// Both logins count against the shared test IP, not the tokens.
+	token, other := benchToken(t, h), benchToken(t, h)
+	status := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/ssl/v1/status/service", nil)
+		req.Header.Set("token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range opts.RateLimit {
+		if rec := status(token); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d: throttled within the limit", i+1)
+		}
+	}
+	rec := status(token)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: got %d %s, want %d", rec.Code, rec.Body, http.StatusTooManyRequests)
+	}
+	// One token every 60s/3.
+	if got := rec.Header().Get("Retry-After"); got != "20" {
+		t.Errorf("Retry-After %q, want 20", got)
+	}
+	var apiErr SectigoError
+	if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil || apiErr.Code != errCodeRateLimited {
+		t.Errorf("body %s, want code %d", rec.Body, errCodeRateLimited)
+	}
+
+	if rec := status(other); rec.Code == http.StatusTooManyRequests {
+		t.Errorf("other token: throttled by the first one")
+	}
+}
+
+// TestStoreFile checks that orders and the next ID survive a restart with
+// -store-file, gzipped or not, and that saving leaves no temporary files.
+func TestStoreFile(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "orders.json")
+	start := func(gzip bool) (*Server, func(method, path, body string) *httptest.ResponseRecorder) {
+		opts := DefaultOptions()
+		opts.IssuanceDelay = 0
+		opts.StoreFile = path
+		opts.StoreGzip = gzip
+		srv, err := NewServer(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := srv.Handler()
+		token := benchToken(t, h)
+		return srv, func(method, path, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("token", token)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec
+		}
+	}
+	enroll := func(serve func(method, path, body string) *httptest.ResponseRecorder) int {
+		rec := serve(http.MethodPost, "/api/ssl/v1/enroll", benchEnrollBody(t))
+		var enrolled EnrollResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &enrolled); err != nil || enrolled.SslId == 0 {
+			t.Fatalf("enroll: %d %s", rec.Code, rec.Body)
+		}
+		return enrolled.SslId
+	}
+
+	srv, serve := start(true)
+	id := enroll(serve)
+	srv.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Errorf("with -store-gzip: store does not start with the gzip magic")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("after saving: %d files in the store directory, want only the store", len(entries))
+	}
+
+	// Loading detects gzip whatever -store-gzip says.
+	srv, serve = start(false)
+	defer srv.Close()
+	rec := serve(http.MethodGet, "/api/ssl/v1/status/"+strconv.Itoa(id), "")
+	var st struct{ Status string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || st.Status != "issued" {
+		t.Fatalf("after restart: status %d %s, want the issued order", rec.Code, rec.Body)
+	}
+	if next := enroll(serve); next != id+1 {
+		t.Errorf("after restart: enrolled ID %d, want %d", next, id+1)
+	}
+	if data, err := os.ReadFile(path); err != nil || !json.Valid(data) {
+		t.Errorf("without -store-gzip: store is not plain JSON (%v)", err)
+	}
+}
+
+// TestLocalizeMostSpecific checks that a message matching several %s
+// patterns always gets the translation of the most specific one.
+func TestLocalizeMostSpecific(t *testing.T) {
+	for range 20 {
+		if got, want := localize("de", "Unsupported format: zip (want pem or der)"), "Nicht unterstütztes Format: zip (erlaubt: pem oder der)"; got != want {
+			t.Fatalf("localize = %q, want %q", got, want)
+		}
+		if got, want := localize("de", "Unsupported format: zip"), "Nicht unterstütztes Format: zip"; got != want {
+			t.Fatalf("localize = %q, want %q", got, want)
+		}
+	}
+}
+
+// TestAdminConfig checks that /admin/config describes the embedded
+// server's Options, not the test binary's flags.
+func TestAdminConfig(t *testing.T) {
+	opts := DefaultOptions()
+	opts.EnableAdmin = true
+	opts.IssuanceDelay = 42 * time.Second
+	srv, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ssl/v1/admin/config", nil))
+	var config map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &config); err != nil {
+		t.Fatalf("config: %d %s", rec.Code, rec.Body)
+	}
+	if got := config["issuanceDelay"]; got != "42s" {
+		t.Errorf("issuanceDelay = %v, want 42s", got)
+	}
+	if catalog, _ := config["catalog"].([]any); len(catalog) != len(defaultCatalog) {
+		t.Errorf("catalog has %d products, want %d", len(catalog), len(defaultCatalog))
+	}
+	if _, ok := config["test.v"]; ok {
+		t.Error("config reports the test binary's flags")
+	}
+}
+
+func benchToken(b testing.TB, h http.Handler) string {
+	req := httptest.NewRequest(http.MethodPost, "/api/ssl/v1/user/auth", strings.NewReader(`{"loginName":"bench","password":"x"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp AuthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		b.Fatalf("auth: %d %s", rec.Code, rec.Body)
+	}
+	return resp.SslId
+}
+
+func benchEnrollBody(b testing.TB) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate("bench.example.com", []string{"bench.example.com"}), key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	body, _ := json.Marshal(EnrollRequest{Csr: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))})
+	return string(body)
+}
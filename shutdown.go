@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// --- Background Work ---
+
+// backgroundWork tracks the delayed state changes scheduled by handlers
+// (issuance, lagged revocations, webhook retries) so reset and shutdown
+// can cancel them.
+type backgroundWork struct {
+	mu     sync.Mutex
+	timers map[*time.Timer]struct{}
+
+	// generation is bumped by stopBackgroundLocked; work scheduled in an
+	// earlier generation does nothing. Guarded by Server.mu, not mu, so
+	// checking it and acting on the orders happen under one lock.
+	generation uint64
+}
+
+// scheduleLocked runs f after d, like time.AfterFunc, but with Server.mu
+// held for writing and only if stopBackgroundLocked has not been called
+// in between. Server.mu must be held, for reading at least.
+func (s *Server) scheduleLocked(d time.Duration, f func()) {
+	gen := s.background.generation
+	s.background.mu.Lock()
+	defer s.background.mu.Unlock()
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		s.background.mu.Lock()
+		delete(s.background.timers, t)
+		s.background.mu.Unlock()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.background.generation == gen {
+			f()
+		}
+	})
+	s.background.timers[t] = struct{}{}
+}
+
+// currentGenerationLocked reports whether gen, read from
+// s.background.generation earlier, is still current. Server.mu must be
+// held, for reading at least.
+func (s *Server) currentGenerationLocked(gen uint64) bool {
+	return s.background.generation == gen
+}
+
+// stopBackgroundLocked cancels all work scheduled so far. Timers that have
+// not fired are stopped; those firing already find the generation changed
+// once they get Server.mu, so nothing is waited for. It returns how many
+// timers were stopped; with -store-file their changes are picked up again
+// on the next start. Server.mu must be held for writing.
+func (s *Server) stopBackgroundLocked() int {
+	s.background.generation++
+	s.background.mu.Lock()
+	defer s.background.mu.Unlock()
+	cancelled := 0
+	for t := range s.background.timers {
+		if t.Stop() {
+			cancelled++
+		}
+		delete(s.background.timers, t)
+	}
+	return cancelled
+}
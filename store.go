@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// --- Order Persistence ---
+
+// storeState is the on-disk layout of -store-file.
+type storeState struct {
+	NextID  int      `json:"nextId"`
+	Orders  []*Order `json:"orders"`
+	Serials []string `json:"serials,omitempty"` // Every serial issued, including those of removed orders
+}
+
+// loadStore reads orders and nextID from path and returns how many orders
+// it loaded. A missing file is not an error: it is created on the first
+// change. A gzipped file is recognized by its magic bytes, whatever
+// -store-gzip says, so the flag can be toggled between runs. Pending orders are rescheduled as if they had just been
+// enrolled, and revocations still waiting on -revoke-lag are applied.
+// Certificates that expired meanwhile are expired by the expirer.
+func (s *Server) loadStore(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if data, err = gunzipStore(data); err != nil {
+		return 0, err
+	}
+	var st storeState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, serial := range st.Serials {
+		s.ca.reserveSerial(serial)
+	}
+	for _, o := range st.Orders {
+		if serial, ok := certSerial(o.Certificate); ok {
+			s.ca.reserveSerial(serial) // Stores written before serials were tracked
+		}
+		if o.UpdatedAt.IsZero() {
+			// Saved before orders tracked changes: use the latest we know.
+			o.UpdatedAt = latest(o.CreatedAt, o.IssuedAt, o.RevokedAt)
+		}
+		if o.ExpiresAt.IsZero() && !o.IssuedAt.IsZero() {
+			o.ExpiresAt = o.IssuedAt.AddDate(0, 0, o.Term) // Saved before orders tracked expiry
+		}
+		s.orders[o.ID] = o
+		if o.ID >= s.nextID {
+			s.nextID = o.ID + 1
+		}
+	}
+	if st.NextID > s.nextID {
+		s.nextID = st.NextID
+	}
+
+	// Only now that the store is complete: these transitions save it.
+	for _, o := range st.Orders {
+		if o.RevokePending {
+			target := "revoked"
+			if o.RevokeReason == reasonCertificateHold {
+				target = "held"
+			}
+			s.applyRevocationLocked(o, target, o.RevokeReason)
+		}
+		if o.Status == "pending" && !o.AwaitingApproval && !o.DCVPending {
+			s.startIssuanceLocked(o)
+		}
+	}
+	return len(st.Orders), nil
+}
+
+// saveStoreLocked writes all orders to -store-file, if set, gzipped with
+// -store-gzip. The file is replaced atomically via a temporary file and rename, so a crash leaves
+// either the old or the new state. mu must be held.
+func (s *Server) saveStoreLocked() {
+	if s.StoreFile == "" {
+		return
+	}
+	st := storeState{NextID: s.nextID, Orders: make([]*Order, 0, len(s.orders)), Serials: s.ca.usedSerials()}
+	for _, o := range s.orders {
+		st.Orders = append(st.Orders, o)
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		log.Printf("[Store] Encoding orders: %v", err)
+		return
+	}
+	if s.StoreGzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data) // Writes to a bytes.Buffer cannot fail
+		zw.Close()
+		data = buf.Bytes()
+	}
+	if err := writeFileAtomic(s.StoreFile, data); err != nil {
+		log.Printf("[Store] Saving %s: %v", s.StoreFile, err)
+	}
+}
+
+// gunzipStore decompresses data if it starts with the gzip magic bytes, and
+// returns it unchanged otherwise.
+func gunzipStore(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// certSerial returns the hex serial of a PEM certificate.
+func certSerial(certPEM string) (string, bool) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return "", false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", false
+	}
+	return cert.SerialNumber.Text(16), true
+}
+
+// latest returns the latest of ts.
+func latest(ts ...time.Time) time.Time {
+	var t time.Time
+	for _, u := range ts {
+		if u.After(t) {
+			t = u
+		}
+	}
+	return t
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it
+// and renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// --- Order Sweeper ---
+
+// completedAt returns when o reached a final state, or false if it is
+// still active. Pending and held orders are active: held can be undone.
+func completedAt(o *Order) (time.Time, bool) {
+	switch o.Status {
+	case "issued":
+		return o.IssuedAt, true
+	case "revoked":
+		return o.RevokedAt, true
+	case "declined":
+		return o.UpdatedAt, true
+	case "expired":
+		return o.ExpiresAt, true
+	}
+	return time.Time{}, false
+}
+
+// sweepOrders deletes completed orders that finished more than ttl ago and
+// returns how many were removed.
+func (s *Server) sweepOrders(ttl time.Duration, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for id, o := range s.orders {
+		if at, done := completedAt(o); done && now.Sub(at) > ttl {
+			delete(s.orders, id)
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.saveStoreLocked()
+	}
+	return removed
+}
+
+// runSweeper periodically applies sweepOrders until the server is closed.
+func (s *Server) runSweeper(ttl time.Duration) {
+	interval := ttl / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if n := s.sweepOrders(ttl, now); n > 0 {
+				log.Printf("[Sweeper] Removed %d completed orders older than %s", n, ttl)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
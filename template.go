@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- Certificate Templates ---
+
+// CertTemplate shapes the leaves issued for a product, so clients can tell
+// an EV certificate from a DV one. Empty fields keep the defaults:
+// digitalSignature (plus keyEncipherment for RSA keys), serverAuth and
+// clientAuth, and no certificate policies.
+type CertTemplate struct {
+	KeyUsage    []string          `json:"keyUsage,omitempty"`    // e.g. digitalSignature, keyEncipherment
+	ExtKeyUsage []string          `json:"extKeyUsage,omitempty"` // e.g. serverAuth, clientAuth
+	Policies    []string          `json:"policies,omitempty"`    // Certificate policy OIDs, e.g. 2.23.140.1.1 for EV
+	Wildcard    bool              `json:"wildcard,omitempty"`    // Cover *.cn and cn, whichever of the two the CSR names
+	Extensions  []CustomExtension `json:"extensions,omitempty"`  // Added as is, e.g. to test unknown critical extensions
+}
+
+// CustomExtension is an arbitrary X.509 extension for issued leaves. Value
+// is the hex DER of the extnValue contents, e.g. 0c03666f6f for the
+// UTF8String "foo".
+type CustomExtension struct {
+	OID      string `json:"oid"`
+	Value    string `json:"value"`
+	Critical bool   `json:"critical,omitempty"`
+}
+
+// extension converts e, which validate has checked.
+func (e CustomExtension) extension() pkix.Extension {
+	var id asn1.ObjectIdentifier
+	for _, arc := range strings.Split(e.OID, ".") {
+		n, _ := strconv.Atoi(arc)
+		id = append(id, n)
+	}
+	value, _ := hex.DecodeString(e.Value)
+	return pkix.Extension{Id: id, Critical: e.Critical, Value: value}
+}
+
+// CA/Browser Forum certificate policies by validation level.
+const (
+	policyDV = "2.23.140.1.2.1"
+	policyOV = "2.23.140.1.2.2"
+	policyEV = "2.23.140.1.1"
+)
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// validate reports the first name or OID in t that issue could not apply.
+func (t *CertTemplate) validate() error {
+	for _, name := range t.KeyUsage {
+		if _, ok := keyUsages[name]; !ok {
+			return fmt.Errorf("unknown key usage %q", name)
+		}
+	}
+	for _, name := range t.ExtKeyUsage {
+		if _, ok := extKeyUsages[name]; !ok {
+			return fmt.Errorf("unknown extended key usage %q", name)
+		}
+	}
+	for _, oid := range t.Policies {
+		if _, err := x509.ParseOID(oid); err != nil {
+			return fmt.Errorf("policy %q: %w", oid, err)
+		}
+	}
+	for _, e := range t.Extensions {
+		if _, err := x509.ParseOID(e.OID); err != nil {
+			return fmt.Errorf("extension %q: %w", e.OID, err)
+		}
+		for _, arc := range strings.Split(e.OID, ".") {
+			if _, err := strconv.Atoi(arc); err != nil {
+				return fmt.Errorf("extension %q: arc %s is too large", e.OID, arc)
+			}
+		}
+		value, err := hex.DecodeString(e.Value)
+		if err != nil {
+			return fmt.Errorf("extension %q: value: %w", e.OID, err)
+		}
+		var raw asn1.RawValue
+		if rest, err := asn1.Unmarshal(value, &raw); err != nil || len(rest) > 0 {
+			return fmt.Errorf("extension %q: value is not a single DER element", e.OID)
+		}
+	}
+	return nil
+}
+
+// apply overrides the defaults in leaf with t. cn is the normalized
+// subject common name, from which Wildcard derives the names to cover.
+func (t *CertTemplate) apply(leaf *x509.Certificate, cn string) {
+	if len(t.KeyUsage) > 0 {
+		leaf.KeyUsage = 0
+		for _, name := range t.KeyUsage {
+			leaf.KeyUsage |= keyUsages[name]
+		}
+	}
+	if len(t.ExtKeyUsage) > 0 {
+		leaf.ExtKeyUsage = nil
+		for _, name := range t.ExtKeyUsage {
+			leaf.ExtKeyUsage = append(leaf.ExtKeyUsage, extKeyUsages[name])
+		}
+	}
+	for _, oid := range t.Policies {
+		id, _ := x509.ParseOID(oid) // Checked by validate
+		leaf.Policies = append(leaf.Policies, id)
+	}
+	for _, e := range t.Extensions {
+		leaf.ExtraExtensions = append(leaf.ExtraExtensions, e.extension())
+	}
+	leaf.DNSNames = t.dnsNames(leaf.DNSNames, cn)
+}
+
+// dnsNames returns the DNS names a leaf for names and the normalized
+// common name cn covers: names, plus *.cn and cn for a wildcard product.
+// t may be nil. Orders record the same names as their SANs.
+func (t *CertTemplate) dnsNames(names []string, cn string) []string {
+	if t == nil || !t.Wildcard || cn == "" {
+		return names
+	}
+	base := strings.TrimPrefix(cn, "*.")
+	for _, name := range []string{"*." + base, base} {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// certTemplate returns the template of the product with code, or nil for
+// the defaults, e.g. for an order stored under a different catalog.
+func (s *Server) certTemplate(code int) *CertTemplate {
+	i := slices.IndexFunc(s.Catalog, func(p Product) bool { return p.Code == code })
+	if i < 0 {
+		return nil
+	}
+	return s.Catalog[i].Template
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+)
+
+// --- TLS ---
+
+// selfSignedTLSCert creates an ephemeral serving certificate for
+// localhost, 127.0.0.1 and ::1, valid for a day. It also returns the
+// certificate's SHA-256 fingerprint (colon-separated hex) and its SPKI
+// pin (base64 SHA-256), for clients that pin either.
+func selfSignedTLSCert() (tls.Certificate, string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", "", err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return tls.Certificate{}, "", "", err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Mock Setigo"}, CommonName: "localhost"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, "", "", err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, "", "", err
+	}
+
+	sum := sha256.Sum256(der)
+	hexSum := strings.ToUpper(hex.EncodeToString(sum[:]))
+	pairs := make([]string, 0, len(sum))
+	for i := 0; i < len(hexSum); i += 2 {
+		pairs = append(pairs, hexSum[i:i+2])
+	}
+	pin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	tc := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+	return tc, strings.Join(pairs, ":"), base64.StdEncoding.EncodeToString(pin[:]), nil
+}
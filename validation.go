@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Custom Validation Status ---
+
+const (
+	stepPending    = "pending"
+	stepInProgress = "in_progress"
+	stepCompleted  = "completed"
+	stepFailed     = "failed"
+)
+
+// defaultValidationSteps is the -validation-steps default.
+const defaultValidationSteps = "domain,organization,callback"
+
+// validationStep is one configured step. A non-empty pinned status is
+// reported as-is instead of being derived from the order's progress.
+type validationStep struct {
+	name   string
+	pinned string
+}
+
+type ValidationStepStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type ValidationStatusResponse struct {
+	SslId  int                    `json:"sslId"`
+	Status string                 `json:"status"`
+	Steps  []ValidationStepStatus `json:"steps"`
+}
+
+func (st validationStep) String() string {
+	if st.pinned == "" {
+		return st.name
+	}
+	return st.name + "=" + st.pinned
+}
+
+// parseValidationSteps parses "name[=status],..." as used by
+// -validation-steps.
+func parseValidationSteps(spec string) ([]validationStep, error) {
+	var steps []validationStep
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, pinned, _ := strings.Cut(entry, "=")
+		switch pinned {
+		case "", stepPending, stepInProgress, stepCompleted, stepFailed:
+		default:
+			return nil, fmt.Errorf("step %q: unknown status %q", name, pinned)
+		}
+		steps = append(steps, validationStep{name: name, pinned: pinned})
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("at least one step is required")
+	}
+	return steps, nil
+}
+
+// validationProgress derives each step's status. Steps of a pending order
+// complete one by one across the issuance delay, with the last finishing
+// only on issuance; any order past pending has completed every step,
+// except a declined one, whose last step failed.
+func (s *Server) validationProgress(o *Order, now time.Time) []ValidationStepStatus {
+	n := len(s.ValidationSteps)
+	done := n
+	status := s.reportedStatus(o, now)
+	switch {
+	case status == "declined":
+		done = n - 1 // The last step is where the order was turned down
+	case o.Status == "pending": // Reported as pending, a progression stage or pending validation
+		done = n - 1
+		if delay, timed := s.issuanceTiming(o); timed && delay > 0 {
+			done = min(done, int(now.Sub(o.pendingSince())*time.Duration(n)/delay))
+		}
+	}
+
+	out := make([]ValidationStepStatus, n)
+	for i, step := range s.ValidationSteps {
+		status := stepPending
+		switch {
+		case step.pinned != "":
+			status = step.pinned
+		case i < done:
+			status = stepCompleted
+		case i == done && status == "declined":
+			status = stepFailed
+		case i == done:
+			status = stepInProgress
+		}
+		out[i] = ValidationStepStatus{Name: step.name, Status: status}
+	}
+	return out
+}
+
+func (s *Server) handleValidation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, errCodeInvalidRequest, "Method not allowed")
+		return
+	}
+
+	if !s.checkSession(w, r) {
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid Order ID format")
+		return
+	}
+
+	now := time.Now()
+	s.mu.RLock()
+	order, ok := s.orders[orderID]
+	var resp ValidationStatusResponse
+	if ok {
+		resp = ValidationStatusResponse{
+			SslId:  orderID,
+			Status: s.reportedStatus(order, now),
+			Steps:  s.validationProgress(order, now),
+		}
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errCodeNotFound, "Order not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// --- Webhooks ---
+//
+// Orders enrolled with a callbackUrl get a POST of {"sslId","status"}
+// when they are issued or revoked. Failed deliveries are retried with
+// exponential backoff.
+
+const (
+	webhookAttempts = 3           // Deliveries tried per status change
+	webhookBackoff  = time.Second // Wait before the first retry; doubles after each
+)
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// WebhookPayload is the body POSTed to an order's callbackUrl.
+type WebhookPayload struct {
+	SslId  int    `json:"sslId"`
+	Status string `json:"status"`
+}
+
+// validCallbackURL reports whether u is an absolute http or https URL.
+func validCallbackURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// notifyLocked queues a webhook for o's current status, if it has a
+// callbackUrl. Delivery runs in the background so the caller's lock is
+// not held across network calls. mu must be held.
+func (s *Server) notifyLocked(o *Order) {
+	if o.CallbackURL == "" {
+		return
+	}
+	payload := WebhookPayload{SslId: o.ID, Status: o.Status}
+	callbackURL := o.CallbackURL
+	go s.deliverWebhook(s.background.generation, callbackURL, payload, 1)
+}
+
+// deliverWebhook makes delivery attempt n and schedules the next one on
+// failure, unless the server has been reset or shut down since gen.
+func (s *Server) deliverWebhook(gen uint64, callbackURL string, payload WebhookPayload, n int) {
+	err := postWebhook(callbackURL, payload)
+	if err == nil {
+		log.Printf("[Webhook] Order %d: delivered %s to %s", payload.SslId, payload.Status, callbackURL)
+		return
+	}
+	if n == webhookAttempts {
+		log.Printf("[Webhook] Order %d: giving up on %s after %d attempts: %v", payload.SslId, callbackURL, n, err)
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.currentGenerationLocked(gen) {
+		log.Printf("[Webhook] Order %d: attempt %d/%d to %s failed, not retrying after reset: %v", payload.SslId, n, webhookAttempts, callbackURL, err)
+		return
+	}
+	backoff := webhookBackoff << (n - 1)
+	log.Printf("[Webhook] Order %d: attempt %d/%d to %s failed, retrying in %s: %v", payload.SslId, n, webhookAttempts, callbackURL, backoff, err)
+	// Posted from its own goroutine: the timer runs it with mu held.
+	s.scheduleLocked(backoff, func() { go s.deliverWebhook(gen, callbackURL, payload, n+1) })
+}
+
+// postWebhook POSTs payload to callbackURL; any non-2xx answer is an
+// error.
+func postWebhook(callbackURL string, payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(callbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}